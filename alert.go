@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// AlertsConfig параметры оповещений.
+type AlertsConfig struct {
+	// WebhookURL адрес, на который отправляется POST-запрос с JSON
+	// {"text": "..."}. Пустой адрес отключает отправку.
+	WebhookURL string `mapstructure:"webhookUrl"`
+}
+
+var alertsConfig AlertsConfig
+
+// alert выводит оповещение в лог и отправляет его на webhook.
+func alert(msg string) {
+	log.Println("ALERT:", msg)
+
+	if alertsConfig.WebhookURL == "" {
+		return
+	}
+
+	b, _ := json.Marshal(map[string]string{"text": msg})
+	client := http.Client{Timeout: 10 * time.Second}
+	res, err := client.Post(alertsConfig.WebhookURL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		log.Println("send alert:", err)
+		return
+	}
+	res.Body.Close()
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/rocketlaunchr/dataframe-go"
+	"github.com/rocketlaunchr/dataframe-go/imports"
+	"gonum.org/v1/gonum/stat"
+	"math"
+	"os"
+	"time"
+)
+
+// BacktestOptions параметры прогона стратегии по истории.
+type BacktestOptions struct {
+	// Balance начальный баланс счета.
+	Balance float64
+	// Fee комиссия биржи в долях от объема сделки.
+	Fee float64
+	// Params параметры стратегии.
+	Params StrategyParams
+	// TradeFrom время, до которого новые позиции не открываются:
+	// свечи до него нужны только для расчета индикаторов.
+	TradeFrom time.Time
+}
+
+// BacktestFill частичное или полное закрытие позиции.
+type BacktestFill struct {
+	Time     time.Time
+	Price    float64
+	Quantity float64
+	Reason   string
+}
+
+// BacktestTrade сделка, совершенная при прогоне по истории.
+type BacktestTrade struct {
+	Side       TradingPosition
+	EntryTime  time.Time
+	EntryPrice float64
+	Quantity   float64
+	Exits      []BacktestFill
+	Fees       float64
+	PnL        float64
+}
+
+// BacktestResult результат прогона стратегии по истории.
+type BacktestResult struct {
+	Trades       []BacktestTrade
+	Equity       []float64
+	StartBalance float64
+	FinalBalance float64
+	MaxDrawdown  float64
+}
+
+// loadKlinesCsv загружает свечи из csv-файла в формате writeKLinesToCsv.
+func loadKlinesCsv(ctx context.Context, filepath string) (*dataframe.DataFrame, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return imports.LoadFromCSV(ctx, file, imports.CSVLoadOptions{
+		DictateDataType: map[string]interface{}{
+			"date":   int64(0),
+			"open":   float64(0),
+			"high":   float64(0),
+			"low":    float64(0),
+			"close":  float64(0),
+			"volume": float64(0),
+		},
+	})
+}
+
+// runBacktest прогоняет стратегию по свечам df так же, как это делает Trade:
+// сигнал проверяется после закрытия каждой свечи, открытая позиция
+// сопровождается стоп-лоссом и лестницей фиксации прибыли.
+func runBacktest(df *dataframe.DataFrame, cfg *Config, opts BacktestOptions) (*BacktestResult, error) {
+	if df.NRows() < 3 {
+		return nil, fmt.Errorf("not enough candles for backtest: %d", df.NRows())
+	}
+
+	p := opts.Params
+	df = PrepareDataFrame(df, p)
+	nRows := df.NRows()
+
+	dates := df.Series[df.MustNameToColumn("date")]
+	high := df.Series[df.MustNameToColumn("high")].(*dataframe.SeriesFloat64).Values
+	low := df.Series[df.MustNameToColumn("low")].(*dataframe.SeriesFloat64).Values
+	closes := df.Series[df.MustNameToColumn("close")].(*dataframe.SeriesFloat64).Values
+
+	candleTime := func(i int) time.Time {
+		return time.UnixMilli(dates.Value(i).(int64))
+	}
+
+	res := &BacktestResult{
+		StartBalance: opts.Balance,
+		Equity:       []float64{opts.Balance},
+	}
+	balance, peak := opts.Balance, opts.Balance
+
+	var trade *BacktestTrade
+	var levels [][]int
+	remaining := 0.0
+
+	exit := func(i int, price, quantity float64, reason string) {
+		quantity = math.Min(quantity, remaining)
+		if quantity <= 0 {
+			return
+		}
+		fee := price * quantity * opts.Fee
+		trade.Exits = append(trade.Exits, BacktestFill{
+			Time:     candleTime(i),
+			Price:    price,
+			Quantity: quantity,
+			Reason:   reason,
+		})
+		trade.Fees += fee
+		trade.PnL += positionPnL(trade.Side, trade.EntryPrice, price, quantity) - fee
+		remaining -= quantity
+
+		if remaining <= 1e-12 {
+			balance += trade.PnL
+			peak = math.Max(peak, balance)
+			res.MaxDrawdown = math.Max(res.MaxDrawdown, peak-balance)
+			res.Equity = append(res.Equity, balance)
+			res.Trades = append(res.Trades, *trade)
+			trade = nil
+		}
+	}
+
+	// свеча c - последняя закрытая свеча
+	for c := 2; c < nRows; c++ {
+		if trade != nil {
+			entry := trade.EntryPrice
+			if trade.Side == LONG {
+				stopPrice := entry * (1 - p.StopPercent)
+				if low[c] < stopPrice {
+					// stop-loss
+					exit(c, stopPrice, remaining, "stop")
+				} else {
+					for len(levels) > 0 && trade != nil && high[c] > entry+float64(levels[0][0]) {
+						// забрать профит
+						exit(c, entry+float64(levels[0][0]), cfg.MaxPositionAmount*(float64(levels[0][1])/10), "take-profit")
+						levels = levels[1:]
+					}
+				}
+			} else {
+				stopPrice := entry * (1 + p.StopPercent)
+				if high[c] > stopPrice {
+					// stop-loss
+					exit(c, stopPrice, remaining, "stop")
+				} else {
+					for len(levels) > 0 && trade != nil && low[c] < entry-float64(levels[0][0]) {
+						// забрать профит
+						exit(c, entry-float64(levels[0][0]), cfg.MaxPositionAmount*(float64(levels[0][1])/10), "take-profit")
+						levels = levels[1:]
+					}
+				}
+			}
+		}
+
+		if trade != nil || candleTime(c).Before(opts.TradeFrom) {
+			continue
+		}
+
+		decision, err := evaluateSignal(df, c-1, p)
+		if err != nil {
+			return nil, err
+		}
+		if decision.Signal == "" {
+			continue
+		}
+
+		fee := closes[c] * cfg.MaxPositionAmount * opts.Fee
+		trade = &BacktestTrade{
+			Side:       decision.Signal,
+			EntryTime:  candleTime(c),
+			EntryPrice: closes[c],
+			Quantity:   cfg.MaxPositionAmount,
+			Fees:       fee,
+			PnL:        -fee,
+		}
+		levels = append([][]int(nil), p.Ladder...)
+		remaining = cfg.MaxPositionAmount
+	}
+
+	if trade != nil {
+		exit(nRows-1, closes[nRows-1], remaining, "end")
+	}
+
+	res.FinalBalance = balance
+
+	return res, nil
+}
+
+// positionPnL прибыль от закрытия quantity позиции side по цене exit.
+func positionPnL(side TradingPosition, entry, exit, quantity float64) float64 {
+	if side == SHORT {
+		return (entry - exit) * quantity
+	}
+	return (exit - entry) * quantity
+}
+
+// BacktestStats сводные показатели прогона.
+type BacktestStats struct {
+	Trades       int
+	Wins         int
+	WinRate      float64
+	NetProfit    float64
+	ProfitFactor float64
+	MaxDrawdown  float64
+	// Sharpe отношение средней прибыли сделки к ее стандартному отклонению.
+	Sharpe float64
+}
+
+// Stats рассчитывает сводные показатели прогона.
+func (r *BacktestResult) Stats() BacktestStats {
+	st := BacktestStats{
+		Trades:      len(r.Trades),
+		NetProfit:   r.FinalBalance - r.StartBalance,
+		MaxDrawdown: r.MaxDrawdown,
+	}
+	if st.Trades == 0 {
+		return st
+	}
+
+	grossProfit, grossLoss := 0.0, 0.0
+	pnl := make([]float64, len(r.Trades))
+	for i, t := range r.Trades {
+		pnl[i] = t.PnL
+		if t.PnL > 0 {
+			st.Wins++
+			grossProfit += t.PnL
+		} else {
+			grossLoss -= t.PnL
+		}
+	}
+
+	st.WinRate = float64(st.Wins) / float64(st.Trades)
+	if grossLoss > 0 {
+		st.ProfitFactor = grossProfit / grossLoss
+	} else if grossProfit > 0 {
+		st.ProfitFactor = math.Inf(1)
+	}
+	if mean, std := stat.MeanStdDev(pnl, nil); std > 0 {
+		st.Sharpe = mean / std
+	}
+
+	return st
+}
+
+// printBacktestReport выводит сводку результатов прогона.
+func printBacktestReport(res *BacktestResult) {
+	st := res.Stats()
+
+	fmt.Printf("Сделок: %d\n", st.Trades)
+	if st.Trades > 0 {
+		fmt.Printf("Прибыльных: %d (%.1f%%)\n", st.Wins, st.WinRate*100)
+		fmt.Printf("Профит-фактор: %.2f\n", st.ProfitFactor)
+	}
+	fmt.Printf("Начальный баланс: %.2f\n", res.StartBalance)
+	fmt.Printf("Итоговый баланс: %.2f\n", res.FinalBalance)
+	fmt.Printf("Прибыль: %.2f\n", st.NetProfit)
+	fmt.Printf("Максимальная просадка: %.2f\n", st.MaxDrawdown)
+}
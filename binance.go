@@ -1,15 +1,17 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/adshao/go-binance/v2/futures"
 	"github.com/rocketlaunchr/dataframe-go"
 	"github.com/rocketlaunchr/dataframe-go/imports"
+	"math"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 type OpenedPosition struct {
@@ -20,6 +22,46 @@ type OpenedPosition struct {
 	Balance    float64
 	EntryPrice float64
 	Other      float64
+	// Hedge счет в режиме хеджирования: LONG и SHORT открываются
+	// независимо друг от друга.
+	Hedge bool
+	// Legs открытые стороны позиции, в одностороннем режиме не больше одной.
+	Legs []PositionLeg
+	// Isolated изолированная маржа по валютной паре.
+	Isolated bool
+	// MaintMargin поддерживающая маржа счета.
+	MaintMargin float64
+	// MarginBalance маржа счета: баланс с нереализованной прибылью.
+	MarginBalance float64
+}
+
+// PositionLeg открытая сторона позиции.
+type PositionLeg struct {
+	Side             TradingPosition
+	Amount           float64
+	EntryPrice       float64
+	Profit           float64
+	MarkPrice        float64
+	LiquidationPrice float64
+}
+
+// Leg возвращает открытую сторону позиции side или nil.
+func (p *OpenedPosition) Leg(side TradingPosition) *PositionLeg {
+	for i := range p.Legs {
+		if p.Legs[i].Side == side {
+			return &p.Legs[i]
+		}
+	}
+	return nil
+}
+
+// GrossAmount суммарный объем всех сторон позиции.
+func (p *OpenedPosition) GrossAmount() float64 {
+	var amount float64
+	for _, l := range p.Legs {
+		amount += l.Amount
+	}
+	return amount
 }
 
 type TradingPosition string
@@ -29,176 +71,282 @@ const (
 	SHORT TradingPosition = "short"
 )
 
-// binanceKlinesFuturesDataframe получает последние limit свечей
-// для указанной валютной пары в opts.
-func binanceKlinesFuturesDataframe(ctx context.Context, bc *futures.Client, limit int, cfg *Config) (*dataframe.DataFrame, error) {
-	klines, err := bc.NewKlinesService().
-		Limit(limit).
-		Symbol(cfg.Symbol).
-		Interval(cfg.Interval).
-		Do(ctx)
+// binanceKlinesFuturesDataframe получает последние limit свечей интервала
+// interval для указанной валютной пары в opts.
+func binanceKlinesFuturesDataframe(ctx context.Context, bc *BinanceClient, limit int, interval string, cfg *Config) (*dataframe.DataFrame, error) {
+	var klines []*futures.Kline
+	err := bc.call(ctx, true, func() (err error) {
+		klines, err = bc.NewKlinesService().
+			Limit(limit).
+			Symbol(cfg.Symbol).
+			Interval(interval).
+			Do(ctx)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if err = writeKLinesToCsv(klines, cfg.KlinesCsvFile); err != nil {
+	csvFile := klinesCsvPath(cfg, interval)
+	if err = writeKLinesToCsv(klines, csvFile); err != nil {
 		return nil, err
 	}
 
-	file, _ := os.Open(cfg.KlinesCsvFile)
+	file, _ := os.Open(csvFile)
 	df, _ := imports.LoadFromCSV(ctx, file, imports.CSVLoadOptions{
 		InferDataTypes: true,
 	})
 
+	// от старых к новым, как в csv-истории: каналы и наклон считаются
+	// по окну предыдущих свечей, а последняя закрытая свеча - limit-2
 	df.Sort(ctx, []dataframe.SortKey{
-		{Key: "date", Desc: true},
+		{Key: "date", Desc: false},
 	})
 
 	return df, nil
 }
 
-// binanceOpenPosition открывает торговую позицию на указанное кол-во валюты
-func binanceOpenPosition(ctx context.Context, bc *futures.Client, position TradingPosition, quantity float64, cfg *Config) error {
-	cryptoPairPrice := binanceCryptoPairPrice(ctx, bc, cfg.Symbol)
-	var closePrice string
+// klinesCsvPath файл последних свечей интервала interval: для основного
+// интервала KlinesCsvFile, для остальных файл рядом с ним.
+func klinesCsvPath(cfg *Config, interval string) string {
+	if interval == cfg.Interval {
+		return cfg.KlinesCsvFile
+	}
+	ext := filepath.Ext(cfg.KlinesCsvFile)
+	return strings.TrimSuffix(cfg.KlinesCsvFile, ext) + "_" + interval + ext
+}
+
+// binanceOpenPosition открывает торговую позицию positionID на указанное кол-во валюты
+func binanceOpenPosition(ctx context.Context, bc *BinanceClient, position TradingPosition, quantity float64, hedge bool, positionID string, cfg *Config) error {
 	var sideType futures.SideType
 
 	if position == LONG {
-		closePrice = fmt.Sprintf("%.2f", cryptoPairPrice*(1+0.01))
 		sideType = futures.SideTypeBuy
 	} else if position == SHORT {
-		closePrice = fmt.Sprintf("%.2f", cryptoPairPrice*(1-0.01))
 		sideType = futures.SideTypeSell
 	} else {
 		return fmt.Errorf("unsupported position type")
 	}
 
-	_, err := bc.NewCreateBatchOrdersService().
-		OrderList([]*futures.CreateOrderService{
-			bc.NewCreateOrderService().
-				Symbol(cfg.Symbol).
-				Side(sideType).
-				Type(futures.OrderTypeLimit).
-				Quantity(fmt.Sprintf("%f", quantity)).
-				TimeInForce(futures.TimeInForceTypeGTC).
-				Price(closePrice),
-		}).
-		Do(ctx)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return binancePlaceOrder(ctx, bc, cfg.Symbol, sideType, positionSide(position, hedge), quantity, false,
+		clientOrderID(cfg, positionID, ActionEntry), cfg.Execution.Entry)
 }
 
-// binanceOpenPosition закрывает торговую позицию на указанное кол-во валюты
-func binanceClosePosition(ctx context.Context, bc *futures.Client, position TradingPosition, quantity float64, cfg *Config) error {
-	cryptoPairPrice := binanceCryptoPairPrice(ctx, bc, cfg.Symbol)
-	var closePrice string
+// binanceClosePosition закрывает торговую позицию на указанное кол-во
+// валюты способом исполнения style. Кол-во ограничивается текущим объемом
+// позиции на бирже, а ордер только сокращает позицию, поэтому закрытие
+// не может открыть позицию в обратную сторону. clientID - ID ордера.
+func binanceClosePosition(ctx context.Context, bc *BinanceClient, position TradingPosition, quantity float64, hedge bool, clientID string, style ExecutionStyle, cfg *Config) error {
 	var sideType futures.SideType
 
 	if position == LONG {
-		closePrice = fmt.Sprintf("%.2f", cryptoPairPrice*(1-0.01))
 		sideType = futures.SideTypeSell
 	} else if position == SHORT {
-		closePrice = fmt.Sprintf("%.2f", cryptoPairPrice*(1+0.01))
 		sideType = futures.SideTypeBuy
 	} else {
 		return fmt.Errorf("unsupported position type")
 	}
 
-	_, err := bc.NewCreateBatchOrdersService().
-		OrderList([]*futures.CreateOrderService{
-			bc.NewCreateOrderService().
-				Symbol(cfg.Symbol).
-				Side(sideType).
-				Type(futures.OrderTypeLimit).
-				Quantity(fmt.Sprintf("%f", quantity)).
-				TimeInForce(futures.TimeInForceTypeGTC).
-				Price(closePrice),
-		}).
-		Do(ctx)
+	live, err := binanceLiveAmount(ctx, bc, cfg.Symbol, position)
 	if err != nil {
 		return err
 	}
+	if live == 0 {
+		fmt.Printf("Позиция %s уже закрыта\n", position)
+		return nil
+	}
+	quantity = math.Min(quantity, live)
+
+	// в режиме хеджирования ордер со стороной позиции и так только сокращает
+	// ее, а параметр reduceOnly биржа в этом режиме отклоняет
+	return binancePlaceOrder(ctx, bc, cfg.Symbol, sideType, positionSide(position, hedge), quantity, !hedge, clientID, style)
+}
+
+// binancePlaceStopOrder выставляет на бирже стоп-лосс по маркировочной цене,
+// закрывающий всю сторону позиции (closePosition). Возвращает ID ордера.
+func binancePlaceStopOrder(ctx context.Context, bc *BinanceClient, position TradingPosition, stopPrice float64, hedge bool, positionID string, cfg *Config) (int64, error) {
+	sideType := futures.SideTypeSell
+	if position == SHORT {
+		sideType = futures.SideTypeBuy
+	}
+
+	clientID := clientOrderID(cfg, positionID, ActionStopOrder)
+	return binanceSubmitOrder(ctx, bc, cfg.Symbol, clientID, func() (int64, error) {
+		res, err := bc.NewCreateOrderService().
+			Symbol(cfg.Symbol).
+			Side(sideType).
+			PositionSide(positionSide(position, hedge)).
+			Type(futures.OrderTypeStopMarket).
+			StopPrice(fmt.Sprintf("%.2f", stopPrice)).
+			WorkingType(futures.WorkingTypeMarkPrice).
+			ClosePosition(true).
+			NewClientOrderID(clientID).
+			Do(ctx, bc.recvWindow())
+		if err != nil {
+			return 0, err
+		}
+		return res.OrderID, nil
+	})
+}
 
+// binanceCancelOrder отменяет ордер по ID.
+func binanceCancelOrder(ctx context.Context, bc *BinanceClient, symbol string, orderID int64) error {
+	return bc.call(ctx, true, func() error {
+		_, err := bc.NewCancelOrderService().Symbol(symbol).OrderID(orderID).Do(ctx, bc.recvWindow())
+		return err
+	})
+}
+
+// binanceCloseLegs закрывает открытые стороны позиции. ID позиций
+// берутся из state. Стороны, открытые вручную (LegState.Manual), бот
+// не закрывает; при nil state, как в команде close-all, закрываются все.
+func binanceCloseLegs(ctx context.Context, bc *BinanceClient, pos *OpenedPosition, state *TradeState, cfg *Config) error {
+	for _, leg := range pos.Legs {
+		if state != nil {
+			if ls := state.Leg(leg.Side); ls != nil && ls.Manual {
+				fmt.Printf("Позиция %s - %f открыта вручную и не закрывается\n", leg.Side, leg.Amount)
+				continue
+			}
+		}
+		fmt.Printf("Закрытие позиции %s - %f\n", leg.Side, leg.Amount)
+		clientID := clientOrderID(cfg, state.PositionID(leg), ActionClose)
+		if err := binanceClosePosition(ctx, bc, leg.Side, leg.Amount, pos.Hedge, clientID, cfg.Execution.Stop, cfg); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// binanceOpenedPositions получает информацию о всех открытых позициях.
-func binanceOpenedPositions(ctx context.Context, bc *futures.Client, symbol string) (*OpenedPosition, error) {
-	var position string
+// positionSide сторона позиции для ордера: в режиме хеджирования
+// LONG или SHORT, в одностороннем режиме BOTH.
+func positionSide(position TradingPosition, hedge bool) futures.PositionSideType {
+	if !hedge {
+		return futures.PositionSideTypeBoth
+	}
+	if position == LONG {
+		return futures.PositionSideTypeLong
+	}
+	return futures.PositionSideTypeShort
+}
 
-	res, err := bc.NewGetAccountService().Do(ctx)
+// binanceOpenedPositions получает информацию об открытых позициях по
+// валютной паре и режим позиций счета.
+func binanceOpenedPositions(ctx context.Context, bc *BinanceClient, symbol string) (*OpenedPosition, error) {
+	hedge, err := bc.PositionMode(ctx)
 	if err != nil {
 		return &OpenedPosition{}, err
 	}
 
-	b, _ := json.Marshal(res.Positions)
-	df, _ := imports.LoadFromJSON(ctx, bytes.NewReader(b))
-
-	f, _ := dataframe.Filter(ctx, df, func(vals map[any]any, row int, nRows int) (dataframe.FilterAction, error) {
-		if vals["symbol"] != symbol {
-			return dataframe.DROP, nil
-		}
-		return dataframe.KEEP, nil
+	var res *futures.Account
+	err = bc.call(ctx, true, func() (err error) {
+		res, err = bc.NewGetAccountService().Do(ctx, bc.recvWindow())
+		return err
 	})
+	if err != nil {
+		return &OpenedPosition{}, err
+	}
 
-	positionAmtIdx := df.MustNameToColumn("positionAmt")
-	amount, _ := strconv.ParseFloat(f.(*dataframe.DataFrame).Series[positionAmtIdx].Value(0).(string), 64)
+	profit, _ := strconv.ParseFloat(res.TotalUnrealizedProfit, 64)
+	balance, _ := strconv.ParseFloat(res.TotalWalletBalance, 64)
+	pos := &OpenedPosition{Profit: profit, Balance: balance, Hedge: hedge}
+	pos.MaintMargin, _ = strconv.ParseFloat(res.TotalMaintMargin, 64)
+	pos.MarginBalance, _ = strconv.ParseFloat(res.TotalMarginBalance, 64)
 
-	leverageIdx := df.MustNameToColumn("leverage")
-	leverage, _ := strconv.ParseFloat(f.(*dataframe.DataFrame).Series[leverageIdx].Value(0).(string), 64)
+	for _, p := range res.Positions {
+		if p.Symbol != symbol {
+			continue
+		}
+		pos.Leverage, _ = strconv.ParseFloat(p.Leverage, 64)
+		pos.Isolated = p.Isolated
 
-	entryPriceIdx := df.MustNameToColumn("entryPrice")
-	entryPrice, _ := strconv.ParseFloat(f.(*dataframe.DataFrame).Series[entryPriceIdx].Value(0).(string), 64)
+		amount, _ := strconv.ParseFloat(p.PositionAmt, 64)
+		if amount == 0 {
+			continue
+		}
+		entryPrice, _ := strconv.ParseFloat(p.EntryPrice, 64)
+		legProfit, _ := strconv.ParseFloat(p.UnrealizedProfit, 64)
 
-	profit, _ := strconv.ParseFloat(res.TotalUnrealizedProfit, 64)
+		leg := PositionLeg{Side: LONG, Amount: math.Abs(amount), EntryPrice: entryPrice, Profit: legProfit}
+		if p.PositionSide == futures.PositionSideTypeShort || (p.PositionSide == futures.PositionSideTypeBoth && amount < 0) {
+			leg.Side = SHORT
+		}
+		pos.Legs = append(pos.Legs, leg)
+		pos.Amount += amount
+	}
 
-	balance, _ := strconv.ParseFloat(res.TotalWalletBalance, 64)
+	// LONG первым, чтобы порядок сторон не зависел от ответа биржи
+	sort.Slice(pos.Legs, func(i, j int) bool { return pos.Legs[i].Side == LONG && pos.Legs[j].Side != LONG })
+	if len(pos.Legs) > 0 {
+		pos.Position = string(pos.Legs[0].Side)
+		pos.EntryPrice = pos.Legs[0].EntryPrice
 
-	if amount > 0 {
-		position = string(LONG)
-	} else if amount < 0 {
-		position = string(SHORT)
-	}
-
-	return &OpenedPosition{
-		Position:   position,
-		Amount:     amount,
-		Profit:     profit,
-		Leverage:   leverage,
-		Balance:    balance,
-		EntryPrice: entryPrice,
-		Other:      0,
-	}, nil
+		if err = binancePositionRisk(ctx, bc, pos, symbol); err != nil {
+			return pos, err
+		}
+	}
+
+	return pos, nil
 }
 
-// binanceCheckAndCloseOrders проверяет все открытые ордера и закрывает их
-func binanceCheckAndCloseOrders(ctx context.Context, bc *futures.Client, symbol string) (bool, error) {
+// binanceCheckAndCloseOrders проверяет все открытые ордера и закрывает
+// ордера бота, стоп-лоссы - только если не указан keepStops. Ордера,
+// выставленные вручную, не трогает.
+func binanceCheckAndCloseOrders(ctx context.Context, bc *BinanceClient, keepStops bool, cfg *Config) (bool, error) {
 	isStop := true
-	orders, _ := bc.NewListOpenOrdersService().
-		Symbol(symbol).
-		Do(ctx)
+	var orders []*futures.Order
+	err := bc.call(ctx, true, func() (err error) {
+		orders, err = bc.NewListOpenOrdersService().
+			Symbol(cfg.Symbol).
+			Do(ctx, bc.recvWindow())
+		return err
+	})
+	if err != nil {
+		return isStop, err
+	}
 
-	if len(orders) > 0 {
+	manual := 0
+	for _, o := range orders {
+		if !isOwnOrder(cfg, o) {
+			manual++
+			continue
+		}
+		if _, action, _ := parseClientOrderID(cfg, o.ClientOrderID); keepStops && action == ActionStopOrder {
+			continue
+		}
 		isStop = false
-		err := bc.NewCancelAllOpenOrdersService().Symbol(symbol).Do(ctx)
-		if err != nil {
+		if err = binanceCancelOrder(ctx, bc, cfg.Symbol, o.OrderID); err != nil {
 			return isStop, err
 		}
 	}
+	if manual > 0 {
+		fmt.Printf("Оставлено ордеров, выставленных вручную: %d\n", manual)
+	}
 
 	return isStop, nil
 }
 
-func binanceCryptoPairPrice(ctx context.Context, bc *futures.Client, symbol string) (price float64) {
-	prices, _ := bc.NewListPricesService().Symbol(symbol).Do(ctx)
+// binanceCryptoPairPrice получает последнюю цену валютной пары.
+func binanceCryptoPairPrice(ctx context.Context, bc *BinanceClient, symbol string) (float64, error) {
+	var prices []*futures.SymbolPrice
+	err := bc.call(ctx, true, func() (err error) {
+		prices, err = bc.NewListPricesService().Symbol(symbol).Do(ctx)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("get %s price: %w", symbol, err)
+	}
 	for _, p := range prices {
-		if p.Symbol == symbol {
-			price, _ = strconv.ParseFloat(p.Price, 64)
-			break
+		if p.Symbol != symbol {
+			continue
+		}
+		price, err := strconv.ParseFloat(p.Price, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse %s price %q: %w", symbol, p.Price, err)
+		}
+		if price <= 0 {
+			return 0, fmt.Errorf("invalid %s price %f", symbol, price)
 		}
+		return price, nil
 	}
-	return
+	return 0, fmt.Errorf("no price for %s", symbol)
 }
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/rocketlaunchr/dataframe-go"
+	"github.com/wcharczuk/go-chart/v2"
+	"github.com/wcharczuk/go-chart/v2/drawing"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	chartWidth       = 1600
+	chartPriceHeight = 600
+	chartPanelHeight = 200
+)
+
+// ChartMarker отметка сделки на графике.
+type ChartMarker struct {
+	Time  time.Time
+	Price float64
+	// Kind тип отметки: entry, take-profit, stop или end.
+	Kind string
+}
+
+// ChartOptions параметры построения графика.
+type ChartOptions struct {
+	Symbol   string
+	Interval string
+	// Dir директория для сохранения графиков.
+	Dir string
+	// Format формат файла: svg или png.
+	Format  string
+	Markers []ChartMarker
+}
+
+// tradeMarkers переводит сделки прогона по истории в отметки на графике.
+func tradeMarkers(trades []BacktestTrade) []ChartMarker {
+	var markers []ChartMarker
+	for _, t := range trades {
+		markers = append(markers, ChartMarker{Time: t.EntryTime, Price: t.EntryPrice, Kind: "entry"})
+		for _, e := range t.Exits {
+			markers = append(markers, ChartMarker{Time: e.Time, Price: e.Price, Kind: e.Reason})
+		}
+	}
+	return markers
+}
+
+// saveChart сохраняет график подготовленного датафрейма: свечи с каналом,
+// точками разворота hcc/lcc и отметками сделок, а под ним панели наклона
+// и ATR. Возвращает путь к сохраненному файлу.
+func saveChart(df *dataframe.DataFrame, opts ChartOptions) (string, error) {
+	if opts.Format != "svg" && opts.Format != "png" {
+		return "", fmt.Errorf("unsupported chart format %q", opts.Format)
+	}
+
+	nRows := df.NRows()
+	if nRows < 2 {
+		return "", fmt.Errorf("not enough candles for chart: %d", nRows)
+	}
+
+	column := func(name string) []float64 {
+		return df.Series[df.MustNameToColumn(name)].(*dataframe.SeriesFloat64).Values
+	}
+	dates := df.Series[df.MustNameToColumn("date")]
+	xValues := make([]float64, nRows)
+	for i := range xValues {
+		xValues[i] = chart.TimeToFloat64(time.UnixMilli(dates.Value(i).(int64)))
+	}
+	xRange := &chart.ContinuousRange{Min: xValues[0], Max: xValues[nRows-1]}
+
+	price := []chart.Series{
+		candlestickSeries{
+			XValues: xValues,
+			Open:    column("open"),
+			High:    column("high"),
+			Low:     column("low"),
+			Close:   column("close"),
+		},
+		lineSeries("chan_max", xValues, column("chan_max"), chart.ColorBlue),
+		lineSeries("chan_min", xValues, column("chan_min"), chart.ColorBlue),
+	}
+	for _, s := range []chart.ContinuousSeries{
+		pointSeries("hcc", xValues, column("hcc"), chart.ColorOrange),
+		pointSeries("lcc", xValues, column("lcc"), chart.ColorCyan),
+	} {
+		if s.Len() > 0 {
+			price = append(price, s)
+		}
+	}
+
+	markerColors := map[string]drawing.Color{
+		"entry":       chart.ColorBlack,
+		"take-profit": chart.ColorGreen,
+		"stop":        chart.ColorRed,
+	}
+	byKind := map[string]*chart.ContinuousSeries{}
+	for _, m := range opts.Markers {
+		s, ok := byKind[m.Kind]
+		if !ok {
+			color, ok := markerColors[m.Kind]
+			if !ok {
+				color = chart.ColorAlternateGray
+			}
+			s = &chart.ContinuousSeries{Name: m.Kind, Style: chart.Style{
+				StrokeWidth: chart.Disabled,
+				DotWidth:    5,
+				DotColor:    color,
+			}}
+			byKind[m.Kind] = s
+		}
+		s.XValues = append(s.XValues, chart.TimeToFloat64(m.Time))
+		s.YValues = append(s.YValues, m.Price)
+	}
+	for _, kind := range []string{"entry", "take-profit", "stop", "end"} {
+		if s, ok := byKind[kind]; ok {
+			price = append(price, s)
+		}
+	}
+
+	panels := []chart.Chart{
+		newChartPanel(fmt.Sprintf("%s %s", opts.Symbol, opts.Interval), chartPriceHeight, xRange, price),
+		newChartPanel("slope", chartPanelHeight, xRange, []chart.Series{
+			lineSeries("slope", xValues, column("slope"), chart.ColorAlternateBlue),
+		}),
+		newChartPanel("ATR", chartPanelHeight, xRange, []chart.Series{
+			lineSeries("ATR", xValues, column("ATR"), chart.ColorAlternateGreen),
+		}),
+	}
+
+	var out []byte
+	var err error
+	if opts.Format == "png" {
+		out, err = renderPanelsPNG(panels)
+	} else {
+		out, err = renderPanelsSVG(panels)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err = os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(opts.Dir, fmt.Sprintf("%s_%s_%s.%s",
+		opts.Symbol, opts.Interval, time.Now().Format("20060102-150405"), opts.Format))
+
+	return path, os.WriteFile(path, out, 0o644)
+}
+
+func newChartPanel(title string, height int, xRange *chart.ContinuousRange, series []chart.Series) chart.Chart {
+	return chart.Chart{
+		Title:  title,
+		Width:  chartWidth,
+		Height: height,
+		Background: chart.Style{
+			Padding: chart.Box{Top: 30, Left: 20, Right: 20, Bottom: 10},
+		},
+		XAxis: chart.XAxis{
+			Range:          xRange,
+			ValueFormatter: chart.TimeValueFormatterWithFormat("01-02 15:04"),
+		},
+		YAxis: chart.YAxis{
+			ValueFormatter: func(v interface{}) string {
+				return fmt.Sprintf("%.2f", v.(float64))
+			},
+		},
+		Series: series,
+	}
+}
+
+// renderPanelsPNG рисует панели одну под другой в одно png-изображение.
+func renderPanelsPNG(panels []chart.Chart) ([]byte, error) {
+	height := 0
+	for _, p := range panels {
+		height += p.Height
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, chartWidth, height))
+
+	top := 0
+	for _, p := range panels {
+		var buf bytes.Buffer
+		if err := p.Render(chart.PNG, &buf); err != nil {
+			return nil, err
+		}
+		img, err := png.Decode(&buf)
+		if err != nil {
+			return nil, err
+		}
+		draw.Draw(canvas, image.Rect(0, top, chartWidth, top+p.Height), img, image.Point{}, draw.Src)
+		top += p.Height
+	}
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, canvas); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// renderPanelsSVG рисует панели одну под другой, вкладывая svg каждой
+// панели в общий svg со смещением по вертикали.
+func renderPanelsSVG(panels []chart.Chart) ([]byte, error) {
+	var body strings.Builder
+	top := 0
+	for _, p := range panels {
+		var buf bytes.Buffer
+		if err := p.Render(chart.SVG, &buf); err != nil {
+			return nil, err
+		}
+		body.WriteString(strings.Replace(buf.String(), "<svg", fmt.Sprintf(`<svg y="%d"`, top), 1))
+		top += p.Height
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="%d" height="%d">`, chartWidth, top)
+	out.WriteString(body.String())
+	out.WriteString("</svg>")
+	return out.Bytes(), nil
+}
+
+func lineSeries(name string, xValues, yValues []float64, color drawing.Color) chart.ContinuousSeries {
+	return chart.ContinuousSeries{
+		Name:    name,
+		Style:   chart.Style{StrokeColor: color, StrokeWidth: 1},
+		XValues: xValues,
+		YValues: finiteValues(yValues),
+	}
+}
+
+// pointSeries рисует точками ненулевые значения, например hcc и lcc.
+func pointSeries(name string, xValues, yValues []float64, color drawing.Color) chart.ContinuousSeries {
+	s := chart.ContinuousSeries{
+		Name:  name,
+		Style: chart.Style{StrokeWidth: chart.Disabled, DotWidth: 3, DotColor: color},
+	}
+	for i, y := range yValues {
+		if y != 0 && !math.IsNaN(y) {
+			s.XValues = append(s.XValues, xValues[i])
+			s.YValues = append(s.YValues, y)
+		}
+	}
+	return s
+}
+
+// finiteValues заменяет NaN и бесконечности нулями, иначе график
+// не может рассчитать диапазон оси.
+func finiteValues(values []float64) []float64 {
+	res := make([]float64, len(values))
+	for i, v := range values {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			res[i] = v
+		}
+	}
+	return res
+}
+
+// candlestickSeries серия японских свечей.
+type candlestickSeries struct {
+	XValues []float64
+	Open    []float64
+	High    []float64
+	Low     []float64
+	Close   []float64
+}
+
+func (cs candlestickSeries) GetName() string           { return "candles" }
+func (cs candlestickSeries) GetStyle() chart.Style     { return chart.Style{} }
+func (cs candlestickSeries) GetYAxis() chart.YAxisType { return chart.YAxisPrimary }
+func (cs candlestickSeries) Len() int                  { return len(cs.XValues) }
+func (cs candlestickSeries) GetBoundedValues(index int) (x, y1, y2 float64) {
+	return cs.XValues[index], cs.Low[index], cs.High[index]
+}
+
+func (cs candlestickSeries) Validate() error {
+	if len(cs.Open) != len(cs.XValues) || len(cs.High) != len(cs.XValues) ||
+		len(cs.Low) != len(cs.XValues) || len(cs.Close) != len(cs.XValues) {
+		return fmt.Errorf("candlestick series must have equal lengths")
+	}
+	return nil
+}
+
+func (cs candlestickSeries) Render(r chart.Renderer, canvasBox chart.Box, xrange, yrange chart.Range, defaults chart.Style) {
+	n := cs.Len()
+	if n == 0 {
+		return
+	}
+
+	halfWidth := int(float64(canvasBox.Width()) / float64(n) * 0.35)
+	if halfWidth < 1 {
+		halfWidth = 1
+	}
+
+	for i := 0; i < n; i++ {
+		x := canvasBox.Left + xrange.Translate(cs.XValues[i])
+		yOpen := canvasBox.Bottom - yrange.Translate(cs.Open[i])
+		yClose := canvasBox.Bottom - yrange.Translate(cs.Close[i])
+
+		color := chart.ColorGreen
+		if cs.Close[i] < cs.Open[i] {
+			color = chart.ColorRed
+		}
+		style := chart.Style{StrokeColor: color, StrokeWidth: 1, FillColor: color}
+
+		// фитиль
+		style.GetStrokeOptions().WriteDrawingOptionsToRenderer(r)
+		r.MoveTo(x, canvasBox.Bottom-yrange.Translate(cs.High[i]))
+		r.LineTo(x, canvasBox.Bottom-yrange.Translate(cs.Low[i]))
+		r.Stroke()
+		r.ResetStyle()
+
+		// тело
+		chart.Draw.Box(r, chart.Box{
+			Top:    chart.MinInt(yOpen, yClose),
+			Bottom: chart.MaxInt(yOpen, yClose),
+			Left:   x - halfWidth,
+			Right:  x + halfWidth,
+		}, style)
+	}
+}
@@ -0,0 +1,860 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/rocketlaunchr/dataframe-go"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// command подкоманда командной строки.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "запустить торговлю", cmdRun},
+		{"backtest", "прогнать стратегию по сохраненным свечам", cmdBacktest},
+		{"optimize", "подобрать параметры стратегии по истории", cmdOptimize},
+		{"walk-forward", "проверить оптимизацию на скользящих окнах вне выборки", cmdWalkForward},
+		{"monte-carlo", "оценить устойчивость сделок прогона методом Монте-Карло", cmdMonteCarlo},
+		{"fetch-klines", "загрузить историю свечей в локальное хранилище", cmdFetchKlines},
+		{"chart", "построить график каналов по последним свечам", cmdChart},
+		{"positions", "показать открытую позицию", cmdPositions},
+		{"orders", "показать открытые ордера", cmdOrders},
+		{"close-all", "отменить ордера и закрыть позицию", cmdCloseAll},
+		{"reconcile", "сверить позиции и ордера на бирже с состоянием бота", cmdReconcile},
+		{"signal", "однократно оценить сигнал и вывести обоснование", cmdSignal},
+		{"funding", "показать ставку финансирования и доходы по паре", cmdFunding},
+		{"limits", "показать использование лимитов запросов к бирже", cmdLimits},
+		{"risk", "состояние риск-менеджера: risk status, risk reset", cmdRisk},
+		{"config", "работа с конфигурацией: config validate", cmdConfig},
+	}
+}
+
+// runCLI разбирает аргументы командной строки и запускает подкоманду.
+func runCLI(args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "help" {
+		printUsage()
+		if len(args) == 0 {
+			return errors.New("command is required")
+		}
+		return nil
+	}
+
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+
+	printUsage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Использование: cryptobot <команда> [флаги]")
+	fmt.Fprintln(os.Stderr, "\nКоманды:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.usage)
+	}
+}
+
+// app общее окружение подкоманд: конфигурация и клиент биржи.
+type app struct {
+	cfg *Config
+	bc  *BinanceClient
+}
+
+// newFlagSet создает набор флагов подкоманды с общим флагом -config.
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", "config", "файл конфигурации")
+	return fs, configFile
+}
+
+// loadApp загружает и проверяет конфигурацию, создает клиент биржи.
+func loadApp(configFile string) (*app, error) {
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	alertsConfig = cfg.Alerts
+
+	return &app{cfg: cfg, bc: newBinanceClient(cfg)}, nil
+}
+
+// loadHistory загружает свечи для прогона по истории: из csv-файла file,
+// если он указан, иначе из локального хранилища за период [from, to].
+// Период для csv-файла не поддерживается.
+func (a *app) loadHistory(file, from, to string) (*dataframe.DataFrame, error) {
+	if file != "" && (from != "" || to != "") {
+		return nil, errors.New("-from and -to cannot be used with -file")
+	}
+	fromTime, err := parseDateFlag(from)
+	if err != nil {
+		return nil, err
+	}
+	toTime, err := parseDateFlag(to)
+	if err != nil {
+		return nil, err
+	}
+
+	if file != "" {
+		return loadKlinesCsv(context.Background(), file)
+	}
+
+	candles, err := NewKlinesStore(a.cfg.KlinesDir).Load(a.cfg.Symbol, a.cfg.Interval)
+	if err != nil {
+		return nil, err
+	}
+	candles = filterCandles(candles, fromTime, toTime)
+	if len(candles) == 0 {
+		return nil, fmt.Errorf("no stored klines for %s %s, run fetch-klines first", a.cfg.Symbol, a.cfg.Interval)
+	}
+
+	return candlesDataFrame(candles), nil
+}
+
+// chartOptions параметры графика из конфигурации, format переопределяет
+// формат, если не пустой.
+func (a *app) chartOptions(format string) ChartOptions {
+	if format == "" {
+		format = a.cfg.ChartFormat
+	}
+	return ChartOptions{
+		Symbol:   a.cfg.Symbol,
+		Interval: a.cfg.Interval,
+		Dir:      a.cfg.ChartsDir,
+		Format:   format,
+	}
+}
+
+// parseDateFlag разбирает дату в формате YYYY-MM-DD, пустая строка
+// означает нулевое время.
+func parseDateFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, value, time.UTC)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: %w", value, err)
+	}
+	return t, nil
+}
+
+func cmdRun(args []string) error {
+	fs, configFile := newFlagSet("run")
+	duration := fs.Duration("duration", 0, "длительность торговли (по умолчанию sessions.maxRunDuration)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	// сигнал останавливает торговлю после завершения текущего шага
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+	runFor := a.cfg.Sessions.MaxRunDuration
+	if *duration > 0 {
+		runFor = *duration
+	}
+	if runFor > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, runFor)
+		defer cancel()
+	}
+
+	calendar, err := LoadTradingCalendar(a.cfg.Sessions)
+	if err != nil {
+		return err
+	}
+
+	hedge, err := a.bc.PositionMode(ctx)
+	if err != nil {
+		return err
+	}
+	if hedge {
+		fmt.Println("Режим позиций: хеджирование")
+	} else {
+		fmt.Println("Режим позиций: односторонний")
+	}
+
+	// торговля не начинается, если плечо или тип маржи не удалось установить
+	if err = binanceApplySymbolSettings(ctx, a.bc, a.cfg.Symbol, a.cfg.SymbolSettings(a.cfg.Symbol)); err != nil {
+		return err
+	}
+
+	rm, err := LoadRiskManager(a.cfg.Risk)
+	if err != nil {
+		return err
+	}
+
+	if a.cfg.Exchange.MetricsAddr != "" {
+		serveMetrics(a.cfg.Exchange.MetricsAddr, a.bc.Limiter())
+	}
+
+	summary := &TradingSummary{Started: time.Now()}
+	if pos, err := binanceOpenedPositions(ctx, a.bc, a.cfg.Symbol); err == nil {
+		summary.StartBalance = pos.Balance
+	}
+
+	state, err := LoadTradeState(a.cfg.TradeStateFile, a.cfg.StrategyParams().Ladder)
+	if err != nil {
+		return err
+	}
+
+	var stream *UserStream
+	if a.cfg.Exchange.UserStream {
+		// без потока бот узнает об исполнениях опросом биржи
+		if stream, err = StartUserStream(ctx, a.bc, a.cfg.Symbol, a.cfg.Exchange.UserStreamKeepalive); err != nil {
+			fmt.Println("Поток данных пользователя недоступен:", err)
+		}
+	}
+
+	// торговля не начинается, пока состояние не сверено с биржей
+	if err = reconcile(ctx, a.bc, state, a.cfg); err != nil {
+		return fmt.Errorf("reconcile: %w", err)
+	}
+
+	err = startTrading(ctx, a.bc, state, stream, a.cfg, rm, calendar, summary)
+
+	return errors.Join(err, shutdownTrading(a.bc, state, a.cfg, rm, summary))
+}
+
+func cmdBacktest(args []string) error {
+	fs, configFile := newFlagSet("backtest")
+	file := fs.String("file", "", "csv-файл со свечами вместо локального хранилища")
+	from := fs.String("from", "", "начало периода, YYYY-MM-DD")
+	to := fs.String("to", "", "конец периода, YYYY-MM-DD")
+	balance := fs.Float64("balance", 1000, "начальный баланс")
+	fee := fs.Float64("fee", 0.0004, "комиссия биржи в долях от объема")
+	withChart := fs.Bool("chart", false, "сохранить график со сделками")
+	format := fs.String("format", "", "формат графика: svg или png (по умолчанию chartFormat)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	df, err := a.loadHistory(*file, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	res, err := runBacktest(df, a.cfg, BacktestOptions{
+		Balance: *balance,
+		Fee:     *fee,
+		Params:  a.cfg.StrategyParams(),
+	})
+	if err != nil {
+		return err
+	}
+
+	printBacktestReport(res)
+
+	if *withChart {
+		opts := a.chartOptions(*format)
+		opts.Markers = tradeMarkers(res.Trades)
+		path, err := saveChart(PrepareDataFrame(df, a.cfg.StrategyParams()), opts)
+		if err != nil {
+			return err
+		}
+		fmt.Println("График сохранен в", path)
+	}
+
+	return nil
+}
+
+// optimizeFlags флаги, общие для подбора параметров и walk-forward анализа.
+type optimizeFlags struct {
+	file, from, to *string
+	balance, fee   *float64
+	ranges         paramRangesFlag
+	search         *string
+	samples        *int
+	seed           *int64
+	objective      *string
+	minTrades      *int
+	workers        *int
+}
+
+func addOptimizeFlags(fs *flag.FlagSet) *optimizeFlags {
+	f := &optimizeFlags{
+		file:      fs.String("file", "", "csv-файл со свечами вместо локального хранилища"),
+		from:      fs.String("from", "", "начало периода, YYYY-MM-DD"),
+		to:        fs.String("to", "", "конец периода, YYYY-MM-DD"),
+		balance:   fs.Float64("balance", 1000, "начальный баланс"),
+		fee:       fs.Float64("fee", 0.0004, "комиссия биржи в долях от объема"),
+		search:    fs.String("search", "grid", "способ перебора: grid или random"),
+		samples:   fs.Int("samples", 100, "кол-во случайных наборов для random"),
+		seed:      fs.Int64("seed", 1, "начальное значение генератора для random"),
+		objective: fs.String("objective", "net-profit", "целевая функция: net-profit, profit-factor, sharpe, win-rate, return-drawdown"),
+		minTrades: fs.Int("min-trades", 5, "минимальное кол-во сделок для ранжирования"),
+		workers:   fs.Int("workers", runtime.NumCPU(), "кол-во параллельных прогонов"),
+	}
+	fs.Var(&f.ranges, "range", "диапазон параметра name=min:max:step, можно указать несколько раз")
+	return f
+}
+
+func (f *optimizeFlags) options(cfg *Config) OptimizeOptions {
+	return OptimizeOptions{
+		Backtest: BacktestOptions{
+			Balance: *f.balance,
+			Fee:     *f.fee,
+			Params:  cfg.StrategyParams(),
+		},
+		Ranges:    f.ranges,
+		Search:    *f.search,
+		Samples:   *f.samples,
+		Seed:      *f.seed,
+		Objective: *f.objective,
+		MinTrades: *f.minTrades,
+		Workers:   *f.workers,
+	}
+}
+
+func cmdOptimize(args []string) error {
+	fs, configFile := newFlagSet("optimize")
+	of := addOptimizeFlags(fs)
+	out := fs.String("out", "./data/optimize.csv", "csv-файл с таблицей результатов")
+	top := fs.Int("top", 10, "кол-во лучших результатов для вывода")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	df, err := a.loadHistory(*of.file, *of.from, *of.to)
+	if err != nil {
+		return err
+	}
+
+	results, err := optimize(df, a.cfg, of.options(a.cfg))
+	if err != nil {
+		return err
+	}
+
+	if err = writeOptimizationCsv(results, of.ranges, *out); err != nil {
+		return err
+	}
+
+	fmt.Printf("Проверено наборов: %d, результаты сохранены в %s\n", len(results), *out)
+	for i, res := range results {
+		if i == *top {
+			break
+		}
+		var vals []string
+		for j, r := range of.ranges {
+			vals = append(vals, fmt.Sprintf("%s=%g", r.Name, res.Values[j]))
+		}
+		fmt.Printf("%d. %s: %s %.4f, сделок %d, прибыль %.2f, просадка %.2f\n",
+			i+1, strings.Join(vals, " "), *of.objective, res.Score, res.Stats.Trades, res.Stats.NetProfit, res.Stats.MaxDrawdown)
+	}
+
+	return nil
+}
+
+func cmdWalkForward(args []string) error {
+	fs, configFile := newFlagSet("walk-forward")
+	of := addOptimizeFlags(fs)
+	inSample := fs.Duration("in", 30*24*time.Hour, "длительность окна оптимизации")
+	outSample := fs.Duration("out", 7*24*time.Hour, "длительность проверочного окна")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	df, err := a.loadHistory(*of.file, *of.from, *of.to)
+	if err != nil {
+		return err
+	}
+
+	step, err := intervalDuration(a.cfg.Interval)
+	if err != nil {
+		return err
+	}
+
+	res, err := walkForward(df, a.cfg, WalkForwardOptions{
+		Optimize:  of.options(a.cfg),
+		InSample:  int(*inSample / step),
+		OutSample: int(*outSample / step),
+	})
+	if err != nil {
+		return err
+	}
+
+	printWalkForwardReport(res)
+
+	return nil
+}
+
+func cmdMonteCarlo(args []string) error {
+	fs, configFile := newFlagSet("monte-carlo")
+	file := fs.String("file", "", "csv-файл со свечами вместо локального хранилища")
+	from := fs.String("from", "", "начало периода, YYYY-MM-DD")
+	to := fs.String("to", "", "конец периода, YYYY-MM-DD")
+	balance := fs.Float64("balance", 1000, "начальный баланс")
+	fee := fs.Float64("fee", 0.0004, "комиссия биржи в долях от объема")
+	runs := fs.Int("runs", 1000, "кол-во симуляций")
+	seed := fs.Int64("seed", 1, "начальное значение генератора")
+	method := fs.String("method", "shuffle", "порядок сделок: shuffle или bootstrap")
+	slippage := fs.Float64("slippage", 0.0005, "максимальное проскальзывание входа в долях от цены")
+	drop := fs.Float64("drop", 0.05, "вероятность пропуска сделки")
+	ruin := fs.Float64("ruin", 0.5, "доля потерянного баланса, считающаяся разорением")
+	confidence := fs.String("confidence", "0.9,0.95,0.99", "уровни доверия через запятую")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	levels, err := parseConfidence(*confidence)
+	if err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	df, err := a.loadHistory(*file, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	bt, err := runBacktest(df, a.cfg, BacktestOptions{
+		Balance: *balance,
+		Fee:     *fee,
+		Params:  a.cfg.StrategyParams(),
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := monteCarlo(bt.Trades, *balance, MonteCarloOptions{
+		Runs:       *runs,
+		Seed:       *seed,
+		Method:     *method,
+		Slippage:   *slippage,
+		DropRate:   *drop,
+		Ruin:       *ruin,
+		Confidence: levels,
+	})
+	if err != nil {
+		return err
+	}
+
+	printBacktestReport(bt)
+	fmt.Println()
+	printMonteCarloReport(res)
+
+	return nil
+}
+
+func cmdFetchKlines(args []string) error {
+	fs, configFile := newFlagSet("fetch-klines")
+	symbol := fs.String("symbol", "", "валютная пара (по умолчанию symbol из конфигурации)")
+	interval := fs.String("interval", "", "интервал свечей (по умолчанию interval из конфигурации)")
+	from := fs.String("from", "", "начало истории, YYYY-MM-DD (по умолчанию 30 дней назад для новой пары)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+	if *symbol == "" {
+		*symbol = a.cfg.Symbol
+	}
+	if *interval == "" {
+		*interval = a.cfg.Interval
+	}
+
+	fromTime, err := parseDateFlag(*from)
+	if err != nil {
+		return err
+	}
+
+	store := NewKlinesStore(a.cfg.KlinesDir)
+	if fromTime.IsZero() {
+		if _, err = os.Stat(store.Path(*symbol, *interval)); errors.Is(err, os.ErrNotExist) {
+			fromTime = time.Now().AddDate(0, 0, -30)
+		}
+	}
+
+	candles, err := store.Update(context.Background(), a.bc, *symbol, *interval, fromTime)
+	if err != nil {
+		return err
+	}
+
+	if len(candles) > 0 {
+		fmt.Printf("Сохранено свечей: %d с %s по %s в %s\n", len(candles),
+			time.UnixMilli(candles[0].OpenTime).Format(time.DateTime),
+			time.UnixMilli(candles[len(candles)-1].OpenTime).Format(time.DateTime),
+			store.Path(*symbol, *interval))
+	}
+
+	return nil
+}
+
+func cmdChart(args []string) error {
+	fs, configFile := newFlagSet("chart")
+	limit := fs.Int("limit", 100, "количество свечей")
+	format := fs.String("format", "", "формат графика: svg или png (по умолчанию chartFormat)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	ohlc, err := binanceKlinesFuturesDataframe(context.Background(), a.bc, *limit, a.cfg.Interval, a.cfg)
+	if err != nil {
+		return err
+	}
+
+	path, err := saveChart(PrepareDataFrame(ohlc, a.cfg.StrategyParams()), a.chartOptions(*format))
+	if err != nil {
+		return err
+	}
+	fmt.Println("График сохранен в", path)
+
+	return nil
+}
+
+func cmdPositions(args []string) error {
+	fs, configFile := newFlagSet("positions")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	pos, err := binanceOpenedPositions(context.Background(), a.bc, a.cfg.Symbol)
+	if err != nil {
+		return err
+	}
+
+	if pos.Hedge {
+		fmt.Println("Режим хеджирования")
+	}
+	if len(pos.Legs) == 0 {
+		fmt.Println("Нет открытых позиций!")
+	}
+	for _, leg := range pos.Legs {
+		fmt.Printf("Позиция: %s %f по %f, прибыль %f\n", leg.Side, leg.Amount, leg.EntryPrice, leg.Profit)
+		fmt.Printf("  Цена ликвидации: %f, до ликвидации %.2f%%\n", leg.LiquidationPrice, leg.LiquidationDistance()*100)
+	}
+	fmt.Printf("Уровень маржи: %.2f%%\n", pos.MarginRatio()*100)
+	fmt.Printf("Плечо: %.0f, маржа: %s\n", pos.Leverage, marginTypeOf(pos))
+	if err = a.cfg.SymbolSettings(a.cfg.Symbol).Check(pos); err != nil {
+		fmt.Println("Настройки не совпадают с конфигурацией:", err)
+	}
+	fmt.Printf("Нереализованная прибыль: %f\n", pos.Profit)
+	fmt.Printf("Баланс: %f\n", pos.Balance)
+
+	return nil
+}
+
+func cmdOrders(args []string) error {
+	fs, configFile := newFlagSet("orders")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var orders []*futures.Order
+	err = a.bc.call(ctx, true, func() (err error) {
+		orders, err = a.bc.NewListOpenOrdersService().
+			Symbol(a.cfg.Symbol).
+			Do(ctx, a.bc.recvWindow())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(orders) == 0 {
+		fmt.Println("Нет открытых ордеров")
+	}
+	for _, o := range orders {
+		owner := "вручную"
+		if isOwnOrder(a.cfg, o) {
+			owner = "бот"
+		}
+		fmt.Printf("%d %s %s %s %s цена %s кол-во %s исполнено %s (%s)\n",
+			o.OrderID, o.ClientOrderID, o.Side, o.Type, o.Status, o.Price, o.OrigQuantity, o.ExecutedQuantity, owner)
+	}
+
+	return nil
+}
+
+func cmdCloseAll(args []string) error {
+	fs, configFile := newFlagSet("close-all")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if _, err = binanceCheckAndCloseOrders(ctx, a.bc, false, a.cfg); err != nil {
+		return err
+	}
+
+	pos, err := binanceOpenedPositions(ctx, a.bc, a.cfg.Symbol)
+	if err != nil {
+		return err
+	}
+	if len(pos.Legs) == 0 {
+		fmt.Println("Нет открытых позиций!")
+		return nil
+	}
+
+	return binanceCloseLegs(ctx, a.bc, pos, nil, a.cfg)
+}
+
+func cmdSignal(args []string) error {
+	fs, configFile := newFlagSet("signal")
+	limit := fs.Int("limit", 100, "количество свечей")
+	explain := fs.Bool("explain", false, "вывести проверенные условия и пороги")
+	last := fs.Int("last", 0, "с -explain: вывести последние N записей журнала сигналов вместо оценки")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	if *explain && *last > 0 {
+		if a.cfg.SignalLogFile == "" {
+			return errors.New("signalLogFile is not configured")
+		}
+		records, err := readSignalRecords(a.cfg.SignalLogFile, *last)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			printSignalRecord(rec)
+		}
+		return nil
+	}
+
+	d, err := checkSignal(context.Background(), a.bc, *limit, a.cfg)
+	if err != nil {
+		return err
+	}
+	if *explain {
+		printSignalRecord(newSignalRecord(d, a.cfg, time.Now()))
+		return nil
+	}
+
+	fmt.Printf("Свеча: %d\n", d.Index)
+	fmt.Printf("Локальный минимум: %t\n", d.LocalMin)
+	fmt.Printf("Локальный максимум: %t\n", d.LocalMax)
+	fmt.Printf("Позиция в канале: %.3f\n", d.PosInChan)
+	fmt.Printf("Наклон: %.2f\n", d.Slope)
+	if d.Signal == "" {
+		fmt.Println("Сигнал: нет")
+	} else {
+		fmt.Printf("Сигнал: %s\n", d.Signal)
+	}
+	if tc := d.Confirm; tc != nil {
+		agree := "да"
+		if !tc.Agree {
+			agree = "нет"
+		}
+		fmt.Printf("Таймфрейм %s (свеча до %s): %s, согласован: %s\n",
+			tc.Interval, tc.Close.Local().Format("2006-01-02 15:04"), tc.Reason, agree)
+	}
+	fmt.Printf("Причина: %s\n", d.Reason)
+
+	return nil
+}
+
+func cmdFunding(args []string) error {
+	fs, configFile := newFlagSet("funding")
+	days := fs.Int("days", 7, "период истории доходов в днях")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	info, err := binanceFundingInfo(ctx, a.bc, a.cfg.Symbol)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Маркировочная цена: %f\n", info.MarkPrice)
+	fmt.Printf("Ставка последнего расчета: %.4f%%\n", info.CurrentRate*100)
+	fmt.Printf("Ожидаемая ставка: %.4f%%, расчет в %s\n", info.PredictedRate*100, info.NextFundingTime.Local().Format(time.DateTime))
+
+	income, err := binanceIncome(ctx, a.bc, a.cfg.Symbol, time.Now().AddDate(0, 0, -*days))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nЗа %d дн.:\n", *days)
+	printIncomeSummary(income)
+
+	return nil
+}
+
+func cmdLimits(args []string) error {
+	fs, configFile := newFlagSet("limits")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	// использование лимитов биржа сообщает в заголовках ответа
+	err = a.bc.call(context.Background(), true, func() error {
+		_, err := a.bc.NewServerTimeService().Do(context.Background())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	printRateLimitMetrics(a.bc.Limiter().Metrics())
+
+	return nil
+}
+
+func cmdReconcile(args []string) error {
+	fs, configFile := newFlagSet("reconcile")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	state, err := LoadTradeState(a.cfg.TradeStateFile, a.cfg.StrategyParams().Ladder)
+	if err != nil {
+		return err
+	}
+
+	return reconcile(context.Background(), a.bc, state, a.cfg)
+}
+
+func cmdRisk(args []string) error {
+	if len(args) == 0 || (args[0] != "status" && args[0] != "reset") {
+		return errors.New("usage: risk status|reset [-config file]")
+	}
+
+	fs, configFile := newFlagSet("risk " + args[0])
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	a, err := loadApp(*configFile)
+	if err != nil {
+		return err
+	}
+
+	rm, err := LoadRiskManager(a.cfg.Risk)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "reset" {
+		if err = rm.Reset(); err != nil {
+			return err
+		}
+		fmt.Println("Блокировка риск-менеджера снята")
+		return nil
+	}
+
+	st := rm.State()
+	if st.Halted {
+		fmt.Printf("Торговля остановлена с %s: %s\n", st.HaltedAt.Local().Format(time.DateTime), st.HaltReason)
+	} else {
+		fmt.Println("Торговля разрешена")
+	}
+	fmt.Printf("Сутки: %s, баланс на начало: %.2f\n", st.Session, st.SessionStartBalance)
+	fmt.Printf("Пик капитала: %.2f\n", st.PeakEquity)
+	fmt.Printf("Убыточных сделок подряд: %d\n", st.ConsecutiveLosses)
+
+	return nil
+}
+
+func cmdConfig(args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return errors.New("usage: config validate [-config file]")
+	}
+
+	fs, configFile := newFlagSet("config validate")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	if err = cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config:\n  %s", strings.ReplaceAll(err.Error(), "\n", "\n  "))
+	}
+
+	fmt.Println("Конфигурация корректна")
+
+	return nil
+}
@@ -1,34 +1,353 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/spf13/viper"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	BotID             string  `mapstructure:"botId"`
-	BinanceAPIKey     string  `mapstructure:"binanceApiKey"`
-	BinanceAPISecret  string  `mapstructure:"binanceApiSecret"`
-	Symbol            string  `mapstructure:"symbol"`
-	Interval          string  `mapstructure:"interval"`
-	MaxPositionAmount float64 `mapstructure:"maxPositionAmount"`
-	StopPercent       float64 `mapstructure:"stopPercent"`
-	KlinesCsvFile     string  `mapstructure:"klinesCsvFile"`
+	BotID             string                  `mapstructure:"botId"`
+	BinanceAPIKey     string                  `mapstructure:"binanceApiKey"`
+	BinanceAPISecret  string                  `mapstructure:"binanceApiSecret"`
+	Symbol            string                  `mapstructure:"symbol"`
+	Interval          string                  `mapstructure:"interval"`
+	MaxPositionAmount float64                 `mapstructure:"maxPositionAmount"`
+	StopPercent       float64                 `mapstructure:"stopPercent"`
+	KlinesCsvFile     string                  `mapstructure:"klinesCsvFile"`
+	KlinesDir         string                  `mapstructure:"klinesDir"`
+	ChartsDir         string                  `mapstructure:"chartsDir"`
+	ChartFormat       string                  `mapstructure:"chartFormat"`
+	TradeStateFile    string                  `mapstructure:"tradeStateFile"`
+	JournalFile       string                  `mapstructure:"journalFile"`
+	SignalLogFile     string                  `mapstructure:"signalLogFile"`
+	Strategy          StrategyParams          `mapstructure:"strategy"`
+	Risk              RiskConfig              `mapstructure:"risk"`
+	Exchange          ExchangeConfig          `mapstructure:"exchange"`
+	Alerts            AlertsConfig            `mapstructure:"alerts"`
+	Shutdown          ShutdownConfig          `mapstructure:"shutdown"`
+	Schedule          ScheduleConfig          `mapstructure:"schedule"`
+	Sessions          SessionsConfig          `mapstructure:"sessions"`
+	Symbols           map[string]SymbolConfig `mapstructure:"symbols"`
+	Funding           FundingConfig           `mapstructure:"funding"`
+	Liquidation       LiquidationConfig       `mapstructure:"liquidation"`
+	Execution         ExecutionConfig         `mapstructure:"execution"`
+	Liquidity         LiquidityConfig         `mapstructure:"liquidity"`
+	Confirm           ConfirmConfig           `mapstructure:"confirm"`
 }
 
-func MustLoadConfig(filename string) *Config {
-	viper.SetConfigName(filename)
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
+// Политики завершения работы.
+const (
+	// ShutdownLeave оставить позицию и ордера.
+	ShutdownLeave = "leave"
+	// ShutdownCancelOrders отменить открытые ордера, кроме стоп-лоссов,
+	// позицию оставить.
+	ShutdownCancelOrders = "cancel-orders"
+	// ShutdownFlatten отменить ордера и закрыть позицию.
+	ShutdownFlatten = "flatten"
+)
+
+// ShutdownConfig параметры завершения работы.
+type ShutdownConfig struct {
+	// Policy действие с позицией и ордерами при остановке.
+	Policy string `mapstructure:"policy"`
+	// Timeout максимальное время на завершение работы.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// StrategyParams параметры торговой стратегии.
+type StrategyParams struct {
+	// ChannelWindow кол-во свечей для расчета канала.
+	ChannelWindow int `mapstructure:"channelWindow"`
+	// SlopeWindow кол-во свечей для расчета наклона.
+	SlopeWindow int `mapstructure:"slopeWindow"`
+	// SlopeThreshold минимальный по модулю наклон для входа, в градусах.
+	SlopeThreshold float64 `mapstructure:"slopeThreshold"`
+	// PosInChanThreshold граница позиции в канале: LONG ниже нее, SHORT выше.
+	PosInChanThreshold float64 `mapstructure:"posInChanThreshold"`
+	// ATRPeriod период индикатора ATR.
+	ATRPeriod int `mapstructure:"atrPeriod"`
+	// StopPercent процент закрытия позиции по стоп-лоссу, берется из Config.
+	StopPercent float64 `mapstructure:"-"`
+	// Ladder уровни фиксации прибыли: отклонение цены от точки входа
+	// и кол-во десятых долей позиции, закрываемых на этом уровне.
+	Ladder [][]int `mapstructure:"ladder"`
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		panic(fmt.Errorf("fatal error config file: %w", err))
+// defaultStrategyParams параметры стратегии по умолчанию.
+func defaultStrategyParams() StrategyParams {
+	return StrategyParams{
+		ChannelWindow:      10,
+		SlopeWindow:        5,
+		SlopeThreshold:     20,
+		PosInChanThreshold: 0.5,
+		ATRPeriod:          14,
+		StopPercent:        0.01,
+		Ladder: [][]int{
+			{20, 1}, {40, 1}, {60, 2}, {80, 2},
+			{100, 2}, {150, 1}, {200, 1}, {200, 0},
+		},
+	}
+}
+
+// StrategyParams возвращает параметры стратегии из конфигурации.
+func (c *Config) StrategyParams() StrategyParams {
+	p := c.Strategy
+	p.StopPercent = c.StopPercent
+	return p
+}
+
+// LoadConfig читает конфигурацию из файла. Имя без расширения ищется
+// в текущей директории, иначе используется указанный путь.
+func LoadConfig(filename string) (*Config, error) {
+	v := viper.New()
+	if filepath.Ext(filename) == "" {
+		v.SetConfigName(filename)
+		v.SetConfigType("yaml")
+		v.AddConfigPath(".")
+	} else {
+		v.SetConfigFile(filename)
+	}
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("fatal error config file: %w", err)
 	}
 
 	var C Config
-	if err := viper.Unmarshal(&C); err != nil {
-		panic(err)
+	v.SetDefault("klinesDir", "./data/klines")
+	v.SetDefault("chartsDir", "./images")
+	v.SetDefault("chartFormat", "svg")
+	v.SetDefault("tradeStateFile", "./data/trade_state.json")
+	v.SetDefault("journalFile", "./data/fills.jsonl")
+	v.SetDefault("signalLogFile", "./data/signals.jsonl")
+	def := defaultStrategyParams()
+	v.SetDefault("strategy.channelWindow", def.ChannelWindow)
+	v.SetDefault("strategy.slopeWindow", def.SlopeWindow)
+	v.SetDefault("strategy.slopeThreshold", def.SlopeThreshold)
+	v.SetDefault("strategy.posInChanThreshold", def.PosInChanThreshold)
+	v.SetDefault("strategy.atrPeriod", def.ATRPeriod)
+	v.SetDefault("strategy.ladder", def.Ladder)
+	v.SetDefault("risk.resetOnNewSession", true)
+	v.SetDefault("risk.stateFile", "./data/risk_state.json")
+	v.SetDefault("exchange.maxRetries", 3)
+	v.SetDefault("exchange.retryBaseDelay", 500*time.Millisecond)
+	v.SetDefault("exchange.retryMaxDelay", 10*time.Second)
+	v.SetDefault("exchange.breakerThreshold", 5)
+	v.SetDefault("exchange.breakerCooldown", 4*time.Minute)
+	v.SetDefault("exchange.recvWindow", 5*time.Second)
+	v.SetDefault("exchange.timeSyncInterval", 30*time.Minute)
+	v.SetDefault("exchange.maxClockDrift", time.Second)
+	v.SetDefault("exchange.rateLimit.weightPerMinute", 2400)
+	v.SetDefault("exchange.rateLimit.ordersPerMinute", 1200)
+	v.SetDefault("exchange.rateLimit.ordersPer10s", 300)
+	v.SetDefault("exchange.rateLimit.headroom", 0.1)
+	v.SetDefault("exchange.rateLimit.maxWait", 30*time.Second)
+	v.SetDefault("exchange.userStream", true)
+	v.SetDefault("exchange.userStreamKeepalive", 30*time.Minute)
+	v.SetDefault("schedule.signalDelay", 5*time.Second)
+	v.SetDefault("schedule.manageInterval", time.Minute)
+	v.SetDefault("sessions.maxRunDuration", 12*time.Hour)
+	v.SetDefault("funding.entryWindow", time.Hour)
+	v.SetDefault("funding.exitWindow", 5*time.Minute)
+	v.SetDefault("liquidation.minDistance", 0.05)
+	v.SetDefault("liquidation.maxMarginRatio", 0.8)
+	v.SetDefault("liquidation.maintMarginRate", 0.005)
+	v.SetDefault("execution.entry.type", ExecIOC)
+	v.SetDefault("execution.entry.maxSlippage", 0.002)
+	v.SetDefault("execution.entry.priceProtection", 0.01)
+	v.SetDefault("execution.takeProfit.type", ExecLimit)
+	v.SetDefault("execution.takeProfit.priceProtection", 0.01)
+	v.SetDefault("execution.stop.type", ExecMarket)
+	v.SetDefault("execution.stop.priceProtection", 0.05)
+	v.SetDefault("liquidity.depthLimit", 20)
+	v.SetDefault("liquidity.quantityStep", 0.001)
+	v.SetDefault("confirm.rule", ConfirmSlope)
+	v.SetDefault("confirm.limit", 100)
+	v.SetDefault("confirm.channelThreshold", 0.5)
+	v.SetDefault("confirm.emaPeriod", 50)
+	v.SetDefault("shutdown.policy", ShutdownLeave)
+	v.SetDefault("shutdown.timeout", 30*time.Second)
+	if err := v.Unmarshal(&C); err != nil {
+		return nil, err
+	}
+
+	return &C, nil
+}
+
+// Validate проверяет корректность значений конфигурации.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.BotID == "" {
+		errs = append(errs, errors.New("botId is required"))
+	} else if err := validateOrderIDs(c); err != nil {
+		errs = append(errs, err)
+	}
+	if c.BinanceAPIKey == "" || c.BinanceAPISecret == "" {
+		errs = append(errs, errors.New("binanceApiKey and binanceApiSecret are required"))
+	}
+	if c.Symbol == "" {
+		errs = append(errs, errors.New("symbol is required"))
+	}
+	if _, err := intervalDuration(c.Interval); err != nil {
+		errs = append(errs, err)
+	}
+	if c.MaxPositionAmount <= 0 {
+		errs = append(errs, errors.New("maxPositionAmount must be positive"))
+	}
+	if c.StopPercent <= 0 || c.StopPercent >= 1 {
+		errs = append(errs, errors.New("stopPercent must be between 0 and 1"))
+	}
+	if c.ChartFormat != "svg" && c.ChartFormat != "png" {
+		errs = append(errs, fmt.Errorf("chartFormat must be svg or png, got %q", c.ChartFormat))
+	}
+	if err := c.StrategyParams().Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Risk.MaxDailyLoss < 0 || c.Risk.MaxOpenNotional < 0 || c.Risk.MaxLeverage < 0 || c.Risk.MaxConsecutiveLosses < 0 {
+		errs = append(errs, errors.New("risk limits must not be negative"))
+	}
+	if c.Risk.MaxDrawdownPercent < 0 || c.Risk.MaxDrawdownPercent >= 1 {
+		errs = append(errs, errors.New("risk.maxDrawdownPercent must be between 0 and 1"))
+	}
+	if c.Exchange.MaxRetries < 0 || c.Exchange.RetryBaseDelay < 0 || c.Exchange.RetryMaxDelay < c.Exchange.RetryBaseDelay {
+		errs = append(errs, errors.New("exchange retry settings must not be negative, retryMaxDelay must not be less than retryBaseDelay"))
+	}
+	if w := c.Exchange.RecvWindow; w <= 0 || w > time.Minute {
+		errs = append(errs, errors.New("exchange.recvWindow must be between 0 and 1m"))
+	}
+	if c.Exchange.TimeSyncInterval < 0 || c.Exchange.MaxClockDrift < 0 {
+		errs = append(errs, errors.New("exchange time sync settings must not be negative"))
+	}
+	if rl := c.Exchange.RateLimit; rl.WeightPerMinute < 0 || rl.OrdersPerMinute < 0 || rl.OrdersPer10s < 0 || rl.MaxWait < 0 {
+		errs = append(errs, errors.New("exchange rate limits must not be negative"))
+	}
+	if h := c.Exchange.RateLimit.Headroom; h < 0 || h >= 1 {
+		errs = append(errs, errors.New("exchange.rateLimit.headroom must be between 0 and 1"))
+	}
+	if k := c.Exchange.UserStreamKeepalive; c.Exchange.UserStream && (k <= 0 || k >= time.Hour) {
+		errs = append(errs, errors.New("exchange.userStreamKeepalive must be between 0 and 1h"))
+	}
+	if c.Schedule.SignalDelay < 0 || c.Schedule.ManageInterval < 0 {
+		errs = append(errs, errors.New("schedule settings must not be negative"))
+	}
+	if step, err := intervalDuration(c.Interval); err == nil && c.Schedule.SignalDelay >= step {
+		errs = append(errs, errors.New("schedule.signalDelay must be less than interval"))
+	}
+	for name, sc := range c.Symbols {
+		if err := sc.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("symbols.%s: %w", strings.ToUpper(name), err))
+		}
+	}
+	if f := c.Funding; f.MaxEntryRate < 0 || f.MaxHoldRate < 0 || f.EntryWindow < 0 || f.ExitWindow < 0 {
+		errs = append(errs, errors.New("funding settings must not be negative"))
+	}
+	if l := c.Liquidation; l.MinDistance < 0 || l.ReduceFraction < 0 || l.ReduceFraction > 1 ||
+		l.MaxMarginRatio < 0 || l.MaxMarginRatio > 1 || l.MaintMarginRate < 0 || l.MaintMarginRate >= 1 {
+		errs = append(errs, errors.New("liquidation settings must be fractions between 0 and 1"))
+	}
+	if err := c.Execution.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Liquidity.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Confirm.Validate(c.Interval); err != nil {
+		errs = append(errs, err)
+	}
+	if _, _, err := parseTradingWindows(c.Sessions); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Sessions.MaxRunDuration < 0 {
+		errs = append(errs, errors.New("sessions.maxRunDuration must not be negative"))
+	}
+	switch c.Shutdown.Policy {
+	case ShutdownLeave, ShutdownCancelOrders, ShutdownFlatten:
+	default:
+		errs = append(errs, fmt.Errorf("shutdown.policy must be %s, %s or %s, got %q",
+			ShutdownLeave, ShutdownCancelOrders, ShutdownFlatten, c.Shutdown.Policy))
+	}
+	if c.Shutdown.Timeout <= 0 {
+		errs = append(errs, errors.New("shutdown.timeout must be positive"))
+	}
+	if c.KlinesCsvFile == "" {
+		errs = append(errs, errors.New("klinesCsvFile is required"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Warmup кол-во свечей, необходимое для расчета индикаторов.
+func (p StrategyParams) Warmup() int {
+	n := p.ChannelWindow
+	if p.SlopeWindow > n {
+		n = p.SlopeWindow
+	}
+	if p.ATRPeriod > n {
+		n = p.ATRPeriod
+	}
+	return n + 3
+}
+
+// Validate проверяет корректность параметров стратегии.
+func (p StrategyParams) Validate() error {
+	var errs []error
+
+	if p.ChannelWindow < 1 {
+		errs = append(errs, errors.New("strategy.channelWindow must be positive"))
+	}
+	if p.SlopeWindow < 2 {
+		errs = append(errs, errors.New("strategy.slopeWindow must be at least 2"))
+	}
+	if p.ATRPeriod < 1 {
+		errs = append(errs, errors.New("strategy.atrPeriod must be positive"))
+	}
+	if p.PosInChanThreshold < 0 || p.PosInChanThreshold > 1 {
+		errs = append(errs, errors.New("strategy.posInChanThreshold must be between 0 and 1"))
+	}
+	contracts := 0
+	for _, level := range p.Ladder {
+		if len(level) != 2 || level[0] < 0 || level[1] < 0 {
+			errs = append(errs, fmt.Errorf("invalid strategy.ladder level %v", level))
+			continue
+		}
+		contracts += level[1]
+	}
+	if contracts > 10 {
+		errs = append(errs, errors.New("strategy.ladder closes more than the whole position"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// intervalDuration переводит интервал свечей Binance в длительность.
+func intervalDuration(interval string) (time.Duration, error) {
+	if interval == "" {
+		return 0, errors.New("interval is required")
+	}
+
+	unit := interval[len(interval)-1]
+	n, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", interval)
+	}
+
+	switch unit {
+	case 'm':
+		return time.Duration(n) * time.Minute, nil
+	case 'h':
+		return time.Duration(n) * time.Hour, nil
+	case 'd':
+		return time.Duration(n) * 24 * time.Hour, nil
+	case 'w':
+		return time.Duration(n) * 7 * 24 * time.Hour, nil
+	case 'M':
+		return time.Duration(n) * 30 * 24 * time.Hour, nil
 	}
 
-	return &C
+	return 0, fmt.Errorf("unsupported interval %q", interval)
 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rocketlaunchr/dataframe-go"
+	"time"
+)
+
+// Правила подтверждения сигнала старшим таймфреймом.
+const (
+	// ConfirmSlope наклон канала: для LONG не ниже MinSlope, для SHORT не выше -MinSlope.
+	ConfirmSlope = "slope"
+	// ConfirmChannel позиция в канале: для LONG не выше ChannelThreshold,
+	// для SHORT не ниже 1-ChannelThreshold.
+	ConfirmChannel = "channel"
+	// ConfirmEMA цена закрытия: для LONG не ниже EMA, для SHORT не выше.
+	ConfirmEMA = "ema"
+)
+
+// ConfirmConfig подтверждение сигнала трендом старшего таймфрейма.
+type ConfirmConfig struct {
+	// Interval старший таймфрейм, пустой - не подтверждать.
+	Interval string `mapstructure:"interval"`
+	// Rule правило: slope, channel или ema.
+	Rule string `mapstructure:"rule"`
+	// Limit кол-во свечей старшего таймфрейма.
+	Limit int `mapstructure:"limit"`
+	// MinSlope минимальный наклон по направлению сигнала, в градусах.
+	MinSlope float64 `mapstructure:"minSlope"`
+	// ChannelThreshold граница позиции в канале старшего таймфрейма.
+	ChannelThreshold float64 `mapstructure:"channelThreshold"`
+	// EMAPeriod период EMA.
+	EMAPeriod int `mapstructure:"emaPeriod"`
+}
+
+// Validate проверяет настройки подтверждения для основного интервала interval.
+func (c ConfirmConfig) Validate(interval string) error {
+	if c.Interval == "" {
+		return nil
+	}
+
+	var errs []error
+	step, err := intervalDuration(c.Interval)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("confirm.interval: %w", err))
+	} else if base, err := intervalDuration(interval); err == nil && step <= base {
+		errs = append(errs, fmt.Errorf("confirm.interval %s must be longer than interval %s", c.Interval, interval))
+	}
+	switch c.Rule {
+	case ConfirmSlope, ConfirmChannel:
+	case ConfirmEMA:
+		if c.EMAPeriod <= 0 || c.EMAPeriod >= c.Limit {
+			errs = append(errs, errors.New("confirm.emaPeriod must be positive and less than confirm.limit"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("confirm.rule must be %s, %s or %s, got %q", ConfirmSlope, ConfirmChannel, ConfirmEMA, c.Rule))
+	}
+	if c.Limit <= 2 || c.Limit > 1500 {
+		errs = append(errs, errors.New("confirm.limit must be between 3 and 1500"))
+	}
+	if c.ChannelThreshold < 0 || c.ChannelThreshold > 1 {
+		errs = append(errs, errors.New("confirm.channelThreshold must be between 0 and 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// TimeframeCheck результат проверки сигнала на старшем таймфрейме.
+type TimeframeCheck struct {
+	Interval string
+	// Close время закрытия свечи старшего таймфрейма, по которой проверен сигнал.
+	Close     time.Time
+	Slope     float64
+	PosInChan float64
+	Price     float64
+	EMA       float64
+	// Value и Threshold значение показателя правила и его порог.
+	Value     float64
+	Threshold float64
+	Agree     bool
+	Reason    string
+}
+
+// alignByClose индекс последней свечи с началом в dates и длительностью
+// step, закрытой к моменту at. -1, если такой свечи нет.
+func alignByClose(dates []int64, step time.Duration, at time.Time) int {
+	idx := -1
+	for i, d := range dates {
+		if time.UnixMilli(d).Add(step).After(at) {
+			break
+		}
+		idx = i
+	}
+	return idx
+}
+
+// ema экспоненциальная скользящая средняя values с периодом period.
+// Первые period-1 значений не определены и равны 0.
+func ema(values []float64, period int) []float64 {
+	out := make([]float64, len(values))
+	if len(values) < period {
+		return out
+	}
+	var sum float64
+	for _, v := range values[:period] {
+		sum += v
+	}
+	out[period-1] = sum / float64(period)
+	k := 2 / float64(period+1)
+	for i := period; i < len(values); i++ {
+		out[i] = values[i]*k + out[i-1]*(1-k)
+	}
+	return out
+}
+
+// check проверяет, согласуется ли тренд старшего таймфрейма df,
+// подготовленного PrepareDataFrame, с сигналом signal в момент at.
+func (c ConfirmConfig) check(df *dataframe.DataFrame, signal TradingPosition, at time.Time) (TimeframeCheck, error) {
+	tc := TimeframeCheck{Interval: c.Interval}
+
+	step, err := intervalDuration(c.Interval)
+	if err != nil {
+		return tc, err
+	}
+	dateSeries := df.Series[df.MustNameToColumn("date")]
+	dates := make([]int64, dateSeries.NRows())
+	for i := range dates {
+		dates[i] = dateSeries.Value(i).(int64)
+	}
+	idx := alignByClose(dates, step, at)
+	if idx < 0 {
+		return tc, fmt.Errorf("no closed %s candle before %s", c.Interval, at.Format(time.DateTime))
+	}
+	tc.Close = time.UnixMilli(dates[idx]).Add(step)
+
+	closes := df.Series[df.MustNameToColumn("close")].(*dataframe.SeriesFloat64).Values
+	tc.Price = closes[idx]
+	tc.Slope, _ = df.Series[df.MustNameToColumn("slope")].Value(idx).(float64)
+	tc.PosInChan, _ = df.Series[df.MustNameToColumn("pos_in_chan")].Value(idx).(float64)
+
+	long := signal == LONG
+	switch c.Rule {
+	case ConfirmSlope:
+		tc.Value, tc.Threshold = tc.Slope, c.MinSlope
+		if long {
+			tc.Agree = tc.Slope >= c.MinSlope
+		} else {
+			tc.Threshold = -c.MinSlope
+			tc.Agree = tc.Slope <= -c.MinSlope
+		}
+		tc.Reason = fmt.Sprintf("наклон %.2f", tc.Slope)
+	case ConfirmChannel:
+		tc.Value, tc.Threshold = tc.PosInChan, c.ChannelThreshold
+		if long {
+			tc.Agree = tc.PosInChan <= c.ChannelThreshold
+		} else {
+			tc.Threshold = 1 - c.ChannelThreshold
+			tc.Agree = tc.PosInChan >= 1-c.ChannelThreshold
+		}
+		tc.Reason = fmt.Sprintf("позиция в канале %.3f", tc.PosInChan)
+	case ConfirmEMA:
+		if idx < c.EMAPeriod-1 {
+			return tc, fmt.Errorf("not enough %s candles for EMA(%d)", c.Interval, c.EMAPeriod)
+		}
+		tc.EMA = ema(closes, c.EMAPeriod)[idx]
+		tc.Value, tc.Threshold = tc.Price, tc.EMA
+		if long {
+			tc.Agree = tc.Price >= tc.EMA
+		} else {
+			tc.Agree = tc.Price <= tc.EMA
+		}
+		tc.Reason = fmt.Sprintf("цена %f, EMA(%d) %f", tc.Price, c.EMAPeriod, tc.EMA)
+	default:
+		return tc, fmt.Errorf("unsupported confirm rule %q", c.Rule)
+	}
+
+	return tc, nil
+}
+
+// confirmSignal проверяет сигнал d, найденный на свече lastCandle основного
+// таймфрейма df, трендом старшего таймфрейма. Свечи выравниваются по
+// времени закрытия: учитывается последняя свеча старшего таймфрейма,
+// закрытая к закрытию lastCandle. При несогласии сигнал снимается.
+func confirmSignal(ctx context.Context, bc *BinanceClient, d SignalDecision, df *dataframe.DataFrame, lastCandle int, cfg *Config) (SignalDecision, error) {
+	base, err := intervalDuration(cfg.Interval)
+	if err != nil {
+		return d, err
+	}
+	at := time.UnixMilli(df.Series[df.MustNameToColumn("date")].Value(lastCandle).(int64)).Add(base)
+
+	ohlc, err := binanceKlinesFuturesDataframe(ctx, bc, cfg.Confirm.Limit, cfg.Confirm.Interval, cfg)
+	if err != nil {
+		return d, err
+	}
+	tc, err := cfg.Confirm.check(PrepareDataFrame(ohlc, cfg.StrategyParams()), d.Signal, at)
+	if err != nil {
+		return d, err
+	}
+
+	d.Confirm = &tc
+	d.addCondition("confirm_"+tc.Interval+"_"+cfg.Confirm.Rule, tc.Value, tc.Threshold, tc.Agree)
+	if !tc.Agree {
+		d.VetoedBy = tc.Interval
+		d.Reason = fmt.Sprintf("сигнал %s отклонен таймфреймом %s: %s", d.Signal, tc.Interval, tc.Reason)
+		d.Signal = ""
+		fmt.Println(d.Reason)
+	}
+	return d, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"github.com/rocketlaunchr/dataframe-go"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAlignByClose(t *testing.T) {
+	start := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	// свечи 1h с 00:00 до 03:00
+	dates := []int64{
+		start.UnixMilli(),
+		start.Add(time.Hour).UnixMilli(),
+		start.Add(2 * time.Hour).UnixMilli(),
+		start.Add(3 * time.Hour).UnixMilli(),
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want int
+	}{
+		{"before first close", start.Add(30 * time.Minute), -1},
+		{"exactly at first close", start.Add(time.Hour), 0},
+		// закрытие свечи 15m в 02:15 видит только свечу 1h, закрытую в 02:00
+		{"between closes", start.Add(2*time.Hour + 15*time.Minute), 1},
+		{"last candle not closed", start.Add(3*time.Hour + 59*time.Minute), 2},
+		{"all closed", start.Add(5 * time.Hour), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := alignByClose(dates, time.Hour, tt.at); got != tt.want {
+				t.Errorf("alignByClose(%s) = %d, want %d", tt.at.Format(time.TimeOnly), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEMA(t *testing.T) {
+	got := ema([]float64{1, 2, 3, 4, 5}, 3)
+	// первое значение - среднее первых трех, далее k = 0.5
+	want := []float64{0, 0, 2, 3, 4}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Fatalf("ema = %v, want %v", got, want)
+		}
+	}
+
+	if got := ema([]float64{1, 2}, 3); got[0] != 0 || got[1] != 0 {
+		t.Errorf("ema of short series = %v", got)
+	}
+}
+
+// confirmFrame датафрейм старшего таймфрейма 1h с ценами закрытия closes
+// и одинаковыми наклоном и позицией в канале у всех свечей.
+func confirmFrame(start time.Time, closes []float64, slope, posInChan float64) *dataframe.DataFrame {
+	n := len(closes)
+	dates := make([]int64, n)
+	slopes, positions := make([]float64, n), make([]float64, n)
+	for i := range dates {
+		dates[i] = start.Add(time.Duration(i) * time.Hour).UnixMilli()
+		slopes[i], positions[i] = slope, posInChan
+	}
+	return dataframe.NewDataFrame(
+		dataframe.NewSeriesInt64("date", nil, dates),
+		dataframe.NewSeriesFloat64("close", nil, closes),
+		dataframe.NewSeriesFloat64("slope", nil, slopes),
+		dataframe.NewSeriesFloat64("pos_in_chan", nil, positions),
+	)
+}
+
+func TestConfirmCheck(t *testing.T) {
+	start := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	at := start.Add(3 * time.Hour)
+
+	tests := []struct {
+		name      string
+		cfg       ConfirmConfig
+		closes    []float64
+		slope     float64
+		posInChan float64
+		signal    TradingPosition
+		want      bool
+	}{
+		{"slope agrees with long", ConfirmConfig{Rule: ConfirmSlope, MinSlope: 5}, []float64{1, 2, 3}, 10, 0.5, LONG, true},
+		{"slope vetoes long", ConfirmConfig{Rule: ConfirmSlope, MinSlope: 5}, []float64{1, 2, 3}, 2, 0.5, LONG, false},
+		{"slope agrees with short", ConfirmConfig{Rule: ConfirmSlope, MinSlope: 5}, []float64{3, 2, 1}, -10, 0.5, SHORT, true},
+		{"slope vetoes short", ConfirmConfig{Rule: ConfirmSlope, MinSlope: 5}, []float64{3, 2, 1}, -2, 0.5, SHORT, false},
+		{"channel agrees with long", ConfirmConfig{Rule: ConfirmChannel, ChannelThreshold: 0.4}, []float64{1, 2, 3}, 0, 0.3, LONG, true},
+		{"channel vetoes long", ConfirmConfig{Rule: ConfirmChannel, ChannelThreshold: 0.4}, []float64{1, 2, 3}, 0, 0.5, LONG, false},
+		{"channel agrees with short", ConfirmConfig{Rule: ConfirmChannel, ChannelThreshold: 0.4}, []float64{1, 2, 3}, 0, 0.7, SHORT, true},
+		{"ema agrees with long", ConfirmConfig{Rule: ConfirmEMA, EMAPeriod: 2}, []float64{1, 2, 3}, 0, 0, LONG, true},
+		{"ema vetoes short", ConfirmConfig{Rule: ConfirmEMA, EMAPeriod: 2}, []float64{1, 2, 3}, 0, 0, SHORT, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Interval = "1h"
+			tc, err := tt.cfg.check(confirmFrame(start, tt.closes, tt.slope, tt.posInChan), tt.signal, at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.Agree != tt.want {
+				t.Errorf("Agree = %t (%s), want %t", tc.Agree, tc.Reason, tt.want)
+			}
+			if !tc.Close.Equal(at) {
+				t.Errorf("Close = %s, want %s", tc.Close, at)
+			}
+		})
+	}
+}
+
+func TestConfirmCheckErrors(t *testing.T) {
+	start := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	df := confirmFrame(start, []float64{1, 2, 3}, 0, 0)
+
+	cfg := ConfirmConfig{Interval: "1h", Rule: ConfirmSlope}
+	if _, err := cfg.check(df, LONG, start.Add(30*time.Minute)); err == nil {
+		t.Error("expected error without closed candle")
+	}
+	cfg = ConfirmConfig{Interval: "1h", Rule: ConfirmEMA, EMAPeriod: 5}
+	if _, err := cfg.check(df, LONG, start.Add(3*time.Hour)); err == nil {
+		t.Error("expected error without enough candles for EMA")
+	}
+}
+
+func TestConfirmConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ConfirmConfig
+		wantErr bool
+	}{
+		{"disabled", ConfirmConfig{}, false},
+		{"valid", ConfirmConfig{Interval: "1h", Rule: ConfirmSlope, Limit: 100, ChannelThreshold: 0.5}, false},
+		{"not longer than interval", ConfirmConfig{Interval: "15m", Rule: ConfirmSlope, Limit: 100}, true},
+		{"unknown rule", ConfirmConfig{Interval: "1h", Rule: "rsi", Limit: 100}, true},
+		{"ema period too long", ConfirmConfig{Interval: "1h", Rule: ConfirmEMA, Limit: 50, EMAPeriod: 50}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate("15m"); (err != nil) != tt.wantErr {
+				t.Errorf("Validate error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
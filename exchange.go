@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen возвращается вместо запроса к бирже, пока
+// предохранитель разомкнут.
+var ErrCircuitOpen = errors.New("exchange circuit breaker is open")
+
+// ExchangeConfig параметры повторов запросов к бирже.
+type ExchangeConfig struct {
+	// MaxRetries кол-во повторов после первой неудачной попытки.
+	MaxRetries int `mapstructure:"maxRetries"`
+	// RetryBaseDelay задержка перед первым повтором, далее удваивается.
+	RetryBaseDelay time.Duration `mapstructure:"retryBaseDelay"`
+	// RetryMaxDelay максимальная задержка между повторами.
+	RetryMaxDelay time.Duration `mapstructure:"retryMaxDelay"`
+	// BreakerThreshold кол-во неудачных вызовов подряд, после которого
+	// предохранитель размыкается.
+	BreakerThreshold int `mapstructure:"breakerThreshold"`
+	// BreakerCooldown время, на которое размыкается предохранитель.
+	BreakerCooldown time.Duration `mapstructure:"breakerCooldown"`
+	// RecvWindow время, в течение которого биржа принимает подписанный запрос.
+	RecvWindow time.Duration `mapstructure:"recvWindow"`
+	// TimeSyncInterval период синхронизации времени с сервером биржи.
+	TimeSyncInterval time.Duration `mapstructure:"timeSyncInterval"`
+	// MaxClockDrift расхождение часов с биржей, при превышении которого
+	// отправляется предупреждение.
+	MaxClockDrift time.Duration `mapstructure:"maxClockDrift"`
+	// RateLimit лимиты запросов к бирже.
+	RateLimit RateLimitConfig `mapstructure:"rateLimit"`
+	// MetricsAddr адрес HTTP-сервера с метриками /debug/vars,
+	// пустой отключает сервер.
+	MetricsAddr string `mapstructure:"metricsAddr"`
+	// UserStream получать исполнения ордеров и изменения позиции через
+	// поток данных пользователя, а не только опросом биржи.
+	UserStream bool `mapstructure:"userStream"`
+	// UserStreamKeepalive период продления ключа потока, биржа закрывает
+	// поток через 60 минут без продления.
+	UserStreamKeepalive time.Duration `mapstructure:"userStreamKeepalive"`
+}
+
+// errorClass класс ошибки запроса к бирже.
+type errorClass int
+
+const (
+	// errorFatal ошибка, которую повтор не исправит.
+	errorFatal errorClass = iota
+	// errorRetryable временная ошибка сети или биржи.
+	errorRetryable
+	// errorRateLimit превышен лимит запросов или ордеров.
+	errorRateLimit
+	// errorTimestamp время запроса вышло за recvWindow.
+	errorTimestamp
+)
+
+func (c errorClass) String() string {
+	switch c {
+	case errorRetryable:
+		return "retryable"
+	case errorRateLimit:
+		return "rate-limit"
+	case errorTimestamp:
+		return "timestamp"
+	}
+	return "fatal"
+}
+
+// classifyError определяет класс ошибки по коду common.APIError
+// или по типу сетевой ошибки.
+func classifyError(err error) errorClass {
+	var rlErr *RateLimitError
+	if errors.As(err, &rlErr) {
+		return errorRateLimit
+	}
+
+	var apiErr *common.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case -1003, -1015:
+			return errorRateLimit
+		case -1021:
+			return errorTimestamp
+		case 0, -1000, -1001, -1006, -1007, -1008:
+			// код 0 означает ответ без тела, например 5xx от балансировщика
+			return errorRetryable
+		}
+		return errorFatal
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return errorFatal
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return errorRetryable
+	}
+
+	return errorFatal
+}
+
+// BinanceClient клиент биржи, через который работают все binance-функции:
+// соблюдает лимиты запросов, повторяет неудачные запросы и размыкает
+// предохранитель при серии сбоев.
+type BinanceClient struct {
+	*futures.Client
+
+	cfg     ExchangeConfig
+	breaker *CircuitBreaker
+	limiter *RateLimiter
+
+	// mu защищает TimeOffset, timeSyncedAt и hedge: клиент используется
+	// из нескольких горутин. Запросы выполняются под блокировкой на
+	// чтение, так как библиотека читает TimeOffset при подписи.
+	mu           sync.RWMutex
+	timeSyncedAt time.Time
+	// hedge режим позиций счета, запрашивается один раз.
+	hedge *bool
+}
+
+// newBinanceClient создает клиент для торговли фьючерсами.
+func newBinanceClient(cfg *Config) *BinanceClient {
+	futures.UseTestnet = true
+	bc := &BinanceClient{
+		Client:  futures.NewClient(cfg.BinanceAPIKey, cfg.BinanceAPISecret),
+		cfg:     cfg.Exchange,
+		breaker: NewCircuitBreaker(cfg.Exchange.BreakerThreshold, cfg.Exchange.BreakerCooldown),
+		limiter: NewRateLimiter(cfg.Exchange.RateLimit, http.DefaultTransport),
+	}
+	bc.HTTPClient = &http.Client{Transport: bc.limiter}
+	return bc
+}
+
+// fork создает клиент с теми же ключами, ограничителем запросов
+// и предохранителем, но со своей синхронизацией времени: запросы
+// клиентов не ждут синхронизации друг друга.
+func (bc *BinanceClient) fork() *BinanceClient {
+	c := &BinanceClient{
+		Client:  futures.NewClient(bc.APIKey, bc.SecretKey),
+		cfg:     bc.cfg,
+		breaker: bc.breaker,
+		limiter: bc.limiter,
+	}
+	c.HTTPClient = bc.HTTPClient
+	return c
+}
+
+// Breaker возвращает предохранитель клиента.
+func (bc *BinanceClient) Breaker() *CircuitBreaker {
+	return bc.breaker
+}
+
+// Limiter возвращает ограничитель запросов клиента.
+func (bc *BinanceClient) Limiter() *RateLimiter {
+	return bc.limiter
+}
+
+// PositionMode сообщает, работает ли счет в режиме хеджирования.
+// Режим запрашивается у биржи при первом вызове и запоминается:
+// биржа не дает сменить его при открытых позициях.
+func (bc *BinanceClient) PositionMode(ctx context.Context) (bool, error) {
+	bc.mu.RLock()
+	hedge := bc.hedge
+	bc.mu.RUnlock()
+	if hedge != nil {
+		return *hedge, nil
+	}
+
+	var res *futures.PositionMode
+	err := bc.call(ctx, true, func() (err error) {
+		res, err = bc.NewGetPositionModeService().Do(ctx, bc.recvWindow())
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("get position mode: %w", err)
+	}
+	bc.mu.Lock()
+	bc.hedge = &res.DualSidePosition
+	bc.mu.Unlock()
+	return res.DualSidePosition, nil
+}
+
+// recvWindow опция подписанных запросов с настроенным recvWindow.
+func (bc *BinanceClient) recvWindow() futures.RequestOption {
+	return futures.WithRecvWindow(bc.cfg.RecvWindow.Milliseconds())
+}
+
+// SyncTime синхронизирует время с сервером биржи: смещение учитывается
+// в метке времени подписанных запросов. Возвращает расхождение часов,
+// положительное, если локальные часы спешат.
+func (bc *BinanceClient) SyncTime(ctx context.Context) (time.Duration, error) {
+	sent := time.Now()
+	serverTime, err := bc.NewServerTimeService().Do(ctx)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+
+	// время сервера соответствует середине запроса
+	local := sent.Add(received.Sub(sent) / 2)
+	drift := local.Sub(time.UnixMilli(serverTime))
+
+	bc.mu.Lock()
+	bc.TimeOffset = drift.Milliseconds()
+	bc.timeSyncedAt = received
+	bc.mu.Unlock()
+
+	if bc.cfg.MaxClockDrift > 0 && drift.Abs() > bc.cfg.MaxClockDrift {
+		alert(fmt.Sprintf("Часы расходятся с биржей на %s, проверьте синхронизацию времени", drift.Round(time.Millisecond)))
+	}
+
+	return drift, nil
+}
+
+// syncTimeIfDue синхронизирует время при первом запросе и далее
+// раз в TimeSyncInterval.
+func (bc *BinanceClient) syncTimeIfDue(ctx context.Context) {
+	bc.mu.RLock()
+	syncedAt := bc.timeSyncedAt
+	bc.mu.RUnlock()
+	if !syncedAt.IsZero() && (bc.cfg.TimeSyncInterval <= 0 || time.Since(syncedAt) < bc.cfg.TimeSyncInterval) {
+		return
+	}
+	if _, err := bc.SyncTime(ctx); err != nil {
+		log.Println("sync server time:", err)
+	}
+}
+
+// timeOffset опережение локальных часов относительно биржи в миллисекундах.
+func (bc *BinanceClient) timeOffset() int64 {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.TimeOffset
+}
+
+// call выполняет запрос fn с повторами. Неидемпотентные запросы,
+// например создание ордера, повторяются только если биржа
+// гарантированно их отклонила: при превышении лимита или ошибке времени.
+func (bc *BinanceClient) call(ctx context.Context, idempotent bool, fn func() error) error {
+	if !bc.breaker.Allow() {
+		return ErrCircuitOpen
+	}
+	bc.syncTimeIfDue(ctx)
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		bc.mu.RLock()
+		err = fn()
+		bc.mu.RUnlock()
+		if err == nil {
+			bc.breaker.Success()
+			return nil
+		}
+
+		class := classifyError(err)
+		if class == errorFatal {
+			// запрос дошел до биржи и был отклонен, связь в порядке
+			bc.breaker.Success()
+			return err
+		}
+		var rlErr *RateLimitError
+		if errors.As(err, &rlErr) {
+			// запрос не отправлялся, повторять его до снятия блокировки бессмысленно
+			return err
+		}
+		if class == errorRetryable && !idempotent {
+			break
+		}
+		if attempt >= bc.cfg.MaxRetries {
+			break
+		}
+
+		if class == errorTimestamp {
+			if _, syncErr := bc.SyncTime(ctx); syncErr != nil {
+				log.Println("sync server time:", syncErr)
+			}
+		}
+
+		delay := bc.backoff(attempt, class)
+		log.Printf("exchange call failed (%s), retry %d/%d in %s: %v", class, attempt+1, bc.cfg.MaxRetries, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
+	if bc.breaker.Failure() {
+		alert(fmt.Sprintf("Запросы к бирже отключены на %s после %d сбоев подряд: %v",
+			bc.cfg.BreakerCooldown, bc.cfg.BreakerThreshold, err))
+	}
+
+	return err
+}
+
+// backoff экспоненциальная задержка со случайным разбросом. При превышении
+// лимита запросов задержка увеличивается в четыре раза.
+func (bc *BinanceClient) backoff(attempt int, class errorClass) time.Duration {
+	delay := float64(bc.cfg.RetryBaseDelay) * math.Pow(2, float64(attempt))
+	if class == errorRateLimit {
+		delay *= 4
+	}
+	delay = math.Min(delay, float64(bc.cfg.RetryMaxDelay))
+	// разброс от половины до полной задержки
+	return time.Duration(delay/2 + rand.Float64()*delay/2)
+}
+
+// CircuitBreaker предохранитель: после threshold неудачных вызовов подряд
+// блокирует запросы на время cooldown, затем пропускает пробный запрос.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+// NewCircuitBreaker создает предохранитель. При threshold <= 0 он
+// никогда не размыкается.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow сообщает, можно ли выполнить запрос.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return !time.Now().Before(cb.openUntil)
+}
+
+// OpenUntil возвращает время, до которого предохранитель разомкнут.
+func (cb *CircuitBreaker) OpenUntil() time.Time {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.openUntil
+}
+
+// Success отмечает успешный вызов.
+func (cb *CircuitBreaker) Success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures = 0
+}
+
+// Failure отмечает неудачный вызов. Возвращает true, если предохранитель
+// разомкнулся.
+func (cb *CircuitBreaker) Failure() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	if cb.threshold <= 0 || cb.failures < cb.threshold {
+		return false
+	}
+
+	// после паузы одна неудача снова размыкает предохранитель
+	cb.failures = cb.threshold - 1
+	cb.openUntil = time.Now().Add(cb.cooldown)
+	return true
+}
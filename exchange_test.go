@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/adshao/go-binance/v2/common"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	apiErr := func(code int64) error {
+		return &common.APIError{Code: code, Message: "test"}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want errorClass
+	}{
+		{"too many requests", apiErr(-1003), errorRateLimit},
+		{"too many orders", apiErr(-1015), errorRateLimit},
+		{"wrapped rate limit", fmt.Errorf("place order: %w", apiErr(-1003)), errorRateLimit},
+		{"local rate limit", &RateLimitError{Until: time.Now()}, errorRateLimit},
+		{"timestamp outside recvWindow", apiErr(-1021), errorTimestamp},
+		{"empty body", apiErr(0), errorRetryable},
+		{"unknown error", apiErr(-1000), errorRetryable},
+		{"disconnected", apiErr(-1001), errorRetryable},
+		{"timeout waiting for backend", apiErr(-1007), errorRetryable},
+		{"insufficient margin", apiErr(-2019), errorFatal},
+		{"reduce only rejected", apiErr(-2022), errorFatal},
+		{"net error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, errorRetryable},
+		{"connection closed", io.EOF, errorRetryable},
+		{"truncated response", fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), errorRetryable},
+		{"deadline", context.DeadlineExceeded, errorRetryable},
+		{"canceled", context.Canceled, errorFatal},
+		{"other", errors.New("invalid response"), errorFatal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBinanceClientCall(t *testing.T) {
+	rateLimit := &common.APIError{Code: -1015}
+	timestamp := &common.APIError{Code: -1021}
+	fatal := &common.APIError{Code: -2019}
+	netErr := &net.OpError{Op: "read", Err: errors.New("connection reset")}
+	local := &RateLimitError{Until: time.Now().Add(time.Minute)}
+
+	tests := []struct {
+		name       string
+		idempotent bool
+		// errs ошибки попыток по порядку, после них попытки успешны
+		errs         []error
+		wantErr      error
+		wantAttempts int
+		wantSyncs    int
+		// wantFailure вызов засчитан предохранителю как неудачный
+		wantFailure bool
+	}{
+		{name: "success", idempotent: true, wantAttempts: 1},
+		{name: "fatal not retried", idempotent: true, errs: []error{fatal}, wantErr: fatal, wantAttempts: 1},
+		{name: "retryable recovers", idempotent: true, errs: []error{netErr}, wantAttempts: 2},
+		{name: "retryable exhausted", idempotent: true, errs: []error{netErr, netErr, netErr}, wantErr: netErr, wantAttempts: 3, wantFailure: true},
+		// ордер мог дойти до биржи, повтор открыл бы позицию дважды
+		{name: "non-idempotent not retried", errs: []error{netErr}, wantErr: netErr, wantAttempts: 1, wantFailure: true},
+		{name: "non-idempotent rate limit retried", errs: []error{rateLimit}, wantAttempts: 2},
+		{name: "non-idempotent timestamp retried after sync", errs: []error{timestamp}, wantAttempts: 2, wantSyncs: 1},
+		{name: "local rate limit not retried", idempotent: true, errs: []error{local}, wantErr: local, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syncs := 0
+			bc := testExchange(t, func(w http.ResponseWriter, r *http.Request) {
+				syncs++
+				_ = json.NewEncoder(w).Encode(map[string]int64{"serverTime": time.Now().UnixMilli()})
+			})
+			bc.cfg = ExchangeConfig{MaxRetries: 2, RetryBaseDelay: time.Millisecond, RetryMaxDelay: time.Millisecond, BreakerThreshold: 1, BreakerCooldown: time.Hour}
+			bc.breaker = NewCircuitBreaker(bc.cfg.BreakerThreshold, bc.cfg.BreakerCooldown)
+
+			attempts := 0
+			err := bc.call(context.Background(), tt.idempotent, func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("call error = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts || syncs != tt.wantSyncs {
+				t.Errorf("attempts %d, time syncs %d, want %d, %d", attempts, syncs, tt.wantAttempts, tt.wantSyncs)
+			}
+			if open := !bc.breaker.Allow(); open != tt.wantFailure {
+				t.Errorf("breaker open %t, want %t", open, tt.wantFailure)
+			}
+		})
+	}
+}
+
+func TestBinanceClientCallBreakerOpen(t *testing.T) {
+	bc := testExchange(t, http.NotFound)
+	bc.breaker = NewCircuitBreaker(1, time.Hour)
+	bc.breaker.Failure()
+
+	called := false
+	err := bc.call(context.Background(), true, func() error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, ErrCircuitOpen) || called {
+		t.Errorf("call with open breaker: error %v, request sent %t", err, called)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := NewCircuitBreaker(3, time.Hour)
+	// истечение паузы без ожидания
+	elapse := func() { cb.openUntil = time.Now().Add(-time.Millisecond) }
+
+	// неудачи подряд до порога
+	if cb.Failure() || cb.Failure() || !cb.Allow() {
+		t.Fatal("breaker opened before threshold")
+	}
+	cb.Success()
+	if cb.Failure() || cb.Failure() {
+		t.Fatal("success did not reset failures")
+	}
+	if !cb.Failure() || cb.Allow() {
+		t.Fatal("breaker closed after threshold failures")
+	}
+	if until := cb.OpenUntil(); until.Before(time.Now().Add(59 * time.Minute)) {
+		t.Errorf("OpenUntil = %s, want cooldown of an hour", until)
+	}
+
+	// после паузы пропускается пробный запрос, его неудача снова размыкает
+	elapse()
+	if !cb.Allow() {
+		t.Fatal("breaker still open after cooldown")
+	}
+	if !cb.Failure() || cb.Allow() {
+		t.Fatal("failed probe did not reopen breaker")
+	}
+
+	// успешный пробный запрос замыкает предохранитель
+	elapse()
+	cb.Success()
+	if cb.Failure() || !cb.Allow() {
+		t.Error("breaker reopened on first failure after successful probe")
+	}
+
+	never := NewCircuitBreaker(0, time.Hour)
+	for i := 0; i < 10; i++ {
+		if never.Failure() || !never.Allow() {
+			t.Fatal("breaker without threshold opened")
+		}
+	}
+}
+
+func TestBinanceClientBackoff(t *testing.T) {
+	bc := &BinanceClient{cfg: ExchangeConfig{RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: time.Second}}
+
+	tests := []struct {
+		name    string
+		attempt int
+		class   errorClass
+		want    time.Duration
+	}{
+		{"first retry", 0, errorRetryable, 100 * time.Millisecond},
+		{"doubles", 2, errorRetryable, 400 * time.Millisecond},
+		{"rate limit waits longer", 1, errorRateLimit, 800 * time.Millisecond},
+		{"capped", 10, errorRetryable, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				// разброс от половины до полной задержки
+				if d := bc.backoff(tt.attempt, tt.class); d < tt.want/2 || d > tt.want {
+					t.Fatalf("backoff = %s, want between %s and %s", d, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBinanceClientConcurrent(t *testing.T) {
+	bc := testExchange(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fapi/v1/time":
+			_ = json.NewEncoder(w).Encode(map[string]int64{"serverTime": time.Now().Add(-time.Second).UnixMilli()})
+		case "/fapi/v1/positionSide/dual":
+			_ = json.NewEncoder(w).Encode(map[string]bool{"dualSidePosition": true})
+		case "/fapi/v1/order":
+			writeAPIError(w, codeOrderNotExist)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	// синхронизация времени перед каждым запросом
+	bc.cfg.TimeSyncInterval = time.Nanosecond
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if hedge, err := bc.PositionMode(context.Background()); err != nil || !hedge {
+				errs <- fmt.Errorf("PositionMode = %t, %v", hedge, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := bc.SyncTime(context.Background()); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			// подписанный запрос читает TimeOffset
+			if order, err := binanceOrderByClientID(context.Background(), bc, "ETHUSDT", "test"); order != nil || err != nil {
+				errs <- fmt.Errorf("order lookup = %v, %v", order, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if offset := bc.timeOffset(); offset < 900 || offset > 1100 {
+		t.Errorf("TimeOffset = %d ms, want about a second", offset)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/adshao/go-binance/v2/futures"
+	"math"
+	"strconv"
+)
+
+// Способы исполнения ордеров.
+const (
+	// ExecMarket рыночный ордер.
+	ExecMarket = "market"
+	// ExecLimit лимитный ордер по лучшей цене своей стороны стакана.
+	ExecLimit = "limit"
+	// ExecPostOnly лимитный ордер только мейкера (GTX), отменяется биржей,
+	// если исполнился бы сразу.
+	ExecPostOnly = "post-only"
+	// ExecIOC лимитный ордер IOC по лучшей цене встречной стороны
+	// с допустимым проскальзыванием.
+	ExecIOC = "ioc"
+)
+
+// ExecutionStyle способ исполнения ордеров одного действия.
+type ExecutionStyle struct {
+	// Type способ исполнения: market, limit, post-only или ioc.
+	Type string `mapstructure:"type"`
+	// MaxSlippage максимальное проскальзывание от лучшей цены для ioc, в долях.
+	MaxSlippage float64 `mapstructure:"maxSlippage"`
+	// PriceProtection максимальное отклонение лучшей цены стакана
+	// от маркировочной цены, в долях. 0 - не проверять.
+	PriceProtection float64 `mapstructure:"priceProtection"`
+}
+
+// ExecutionConfig способы исполнения ордеров для входа, фиксации прибыли
+// и стоп-лосса. Стоп-лосс используется и для принудительного закрытия позиции.
+type ExecutionConfig struct {
+	Entry      ExecutionStyle `mapstructure:"entry"`
+	TakeProfit ExecutionStyle `mapstructure:"takeProfit"`
+	Stop       ExecutionStyle `mapstructure:"stop"`
+}
+
+// Validate проверяет способ исполнения.
+func (s ExecutionStyle) Validate() error {
+	switch s.Type {
+	case ExecMarket, ExecLimit, ExecPostOnly, ExecIOC:
+	default:
+		return fmt.Errorf("type must be %s, %s, %s or %s, got %q", ExecMarket, ExecLimit, ExecPostOnly, ExecIOC, s.Type)
+	}
+	if s.MaxSlippage < 0 || s.MaxSlippage >= 1 || s.PriceProtection < 0 || s.PriceProtection >= 1 {
+		return errors.New("maxSlippage and priceProtection must be between 0 and 1")
+	}
+	return nil
+}
+
+// Validate проверяет способы исполнения всех действий.
+func (c ExecutionConfig) Validate() error {
+	var errs []error
+	styles := []struct {
+		name  string
+		style ExecutionStyle
+	}{{"entry", c.Entry}, {"takeProfit", c.TakeProfit}, {"stop", c.Stop}}
+	for _, s := range styles {
+		if err := s.style.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("execution.%s: %w", s.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// price цена лимитного ордера по лучшим ценам стакана bid и ask.
+func (s ExecutionStyle) price(side futures.SideType, bid, ask float64) float64 {
+	buy := side == futures.SideTypeBuy
+	switch s.Type {
+	case ExecLimit, ExecPostOnly:
+		if buy {
+			return bid
+		}
+		return ask
+	case ExecIOC:
+		if buy {
+			return ask * (1 + s.MaxSlippage)
+		}
+		return bid * (1 - s.MaxSlippage)
+	}
+	return 0
+}
+
+// checkPriceProtection проверяет, что цена стакана, по которой исполнится
+// ордер, не отклоняется от маркировочной цены больше допустимого.
+func (s ExecutionStyle) checkPriceProtection(side futures.SideType, bid, ask, mark float64) error {
+	if s.PriceProtection <= 0 || mark <= 0 {
+		return nil
+	}
+	ref := bid
+	if side == futures.SideTypeBuy {
+		ref = ask
+	}
+	if deviation := math.Abs(ref-mark) / mark; deviation > s.PriceProtection {
+		return fmt.Errorf("price protection: book price %f deviates from mark price %f by %.2f%%", ref, mark, deviation*100)
+	}
+	return nil
+}
+
+// binanceBookTicker получает лучшие цены покупки и продажи.
+func binanceBookTicker(ctx context.Context, bc *BinanceClient, symbol string) (bid, ask float64, err error) {
+	var tickers []*futures.BookTicker
+	err = bc.call(ctx, true, func() (err error) {
+		tickers, err = bc.NewListBookTickersService().Symbol(symbol).Do(ctx)
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, t := range tickers {
+		if t.Symbol == symbol {
+			bid, _ = strconv.ParseFloat(t.BidPrice, 64)
+			ask, _ = strconv.ParseFloat(t.AskPrice, 64)
+			return bid, ask, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("no book ticker for %s", symbol)
+}
+
+// binanceMarkPrice получает маркировочную цену.
+func binanceMarkPrice(ctx context.Context, bc *BinanceClient, symbol string) (float64, error) {
+	var index []*futures.PremiumIndex
+	err := bc.call(ctx, true, func() (err error) {
+		index, err = bc.NewPremiumIndexService().Symbol(symbol).Do(ctx)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(index) == 0 {
+		return 0, fmt.Errorf("no premium index for %s", symbol)
+	}
+	return strconv.ParseFloat(index[0].MarkPrice, 64)
+}
+
+// binancePlaceOrder выставляет ордер с ID clientID на quantity способом
+// style. Ордер с reduceOnly может только уменьшить позицию.
+func binancePlaceOrder(ctx context.Context, bc *BinanceClient, symbol string, side futures.SideType, posSide futures.PositionSideType, quantity float64, reduceOnly bool, clientID string, style ExecutionStyle) error {
+	bid, ask, err := binanceBookTicker(ctx, bc, symbol)
+	if err != nil {
+		return err
+	}
+	if style.PriceProtection > 0 {
+		mark, err := binanceMarkPrice(ctx, bc, symbol)
+		if err != nil {
+			return err
+		}
+		if err = style.checkPriceProtection(side, bid, ask, mark); err != nil {
+			return err
+		}
+	}
+
+	order := bc.NewCreateOrderService().
+		Symbol(symbol).
+		Side(side).
+		PositionSide(posSide).
+		Quantity(fmt.Sprintf("%f", quantity)).
+		NewClientOrderID(clientID)
+	if reduceOnly {
+		order.ReduceOnly(true)
+	}
+
+	switch style.Type {
+	case ExecMarket:
+		order.Type(futures.OrderTypeMarket)
+	case ExecLimit:
+		order.Type(futures.OrderTypeLimit).TimeInForce(futures.TimeInForceTypeGTC)
+	case ExecPostOnly:
+		order.Type(futures.OrderTypeLimit).TimeInForce(futures.TimeInForceTypeGTX)
+	case ExecIOC:
+		order.Type(futures.OrderTypeLimit).TimeInForce(futures.TimeInForceTypeIOC)
+	default:
+		return fmt.Errorf("unsupported execution type %q", style.Type)
+	}
+	if price := style.price(side, bid, ask); price > 0 {
+		order.Price(fmt.Sprintf("%.2f", price))
+	}
+
+	_, err = binanceSubmitOrder(ctx, bc, symbol, clientID, func() (int64, error) {
+		res, err := order.Do(ctx, bc.recvWindow())
+		if err != nil {
+			return 0, err
+		}
+		return res.OrderID, nil
+	})
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/adshao/go-binance/v2/futures"
+	"math"
+	"testing"
+)
+
+func TestExecutionStylePrice(t *testing.T) {
+	const bid, ask = 100.0, 101.0
+
+	tests := []struct {
+		name  string
+		style ExecutionStyle
+		side  futures.SideType
+		want  float64
+	}{
+		{"market", ExecutionStyle{Type: ExecMarket}, futures.SideTypeBuy, 0},
+		{"limit buy at bid", ExecutionStyle{Type: ExecLimit}, futures.SideTypeBuy, bid},
+		{"limit sell at ask", ExecutionStyle{Type: ExecLimit}, futures.SideTypeSell, ask},
+		{"post-only buy at bid", ExecutionStyle{Type: ExecPostOnly}, futures.SideTypeBuy, bid},
+		{"ioc buy above ask", ExecutionStyle{Type: ExecIOC, MaxSlippage: 0.01}, futures.SideTypeBuy, 102.01},
+		{"ioc sell below bid", ExecutionStyle{Type: ExecIOC, MaxSlippage: 0.01}, futures.SideTypeSell, 99},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.style.price(tt.side, bid, ask); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("price = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecutionStylePriceProtection(t *testing.T) {
+	tests := []struct {
+		name       string
+		protection float64
+		side       futures.SideType
+		bid, ask   float64
+		mark       float64
+		wantErr    bool
+	}{
+		{"disabled", 0, futures.SideTypeBuy, 90, 110, 100, false},
+		{"no mark price", 0.01, futures.SideTypeBuy, 90, 110, 0, false},
+		{"buy within", 0.01, futures.SideTypeBuy, 99.5, 100.5, 100, false},
+		{"buy checks ask", 0.01, futures.SideTypeBuy, 100, 102, 100, true},
+		{"sell checks bid", 0.01, futures.SideTypeSell, 98, 100, 100, true},
+		{"sell ignores ask", 0.01, futures.SideTypeSell, 100, 102, 100, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := ExecutionStyle{Type: ExecLimit, PriceProtection: tt.protection}
+			err := s.checkPriceProtection(tt.side, tt.bid, tt.ask, tt.mark)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkPriceProtection error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecutionStyleValidate(t *testing.T) {
+	tests := []struct {
+		style   ExecutionStyle
+		wantErr bool
+	}{
+		{ExecutionStyle{Type: ExecMarket}, false},
+		{ExecutionStyle{Type: ExecIOC, MaxSlippage: 0.002, PriceProtection: 0.01}, false},
+		{ExecutionStyle{Type: "stop"}, true},
+		{ExecutionStyle{Type: ExecIOC, MaxSlippage: -0.1}, true},
+		{ExecutionStyle{Type: ExecLimit, PriceProtection: 1}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.style.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) error = %v, want error %t", tt.style, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/adshao/go-binance/v2/futures"
+	"strconv"
+	"time"
+)
+
+// incomePageLimit максимальное кол-во записей в одном запросе истории доходов.
+const incomePageLimit = 1000
+
+// FundingConfig учет ставки финансирования бессрочных контрактов.
+// Ставка задается в долях: 0.0005 = 0.05%.
+type FundingConfig struct {
+	// MaxEntryRate максимальная ставка, которую позиция заплатит при
+	// ближайшем расчете, для входа в течение EntryWindow до расчета.
+	// 0 - не проверять.
+	MaxEntryRate float64 `mapstructure:"maxEntryRate"`
+	// EntryWindow время до расчета, в течение которого проверяется MaxEntryRate.
+	EntryWindow time.Duration `mapstructure:"entryWindow"`
+	// ExitBeforeSettlement закрывать позицию перед расчетом, если она
+	// заплатит ставку выше MaxHoldRate.
+	ExitBeforeSettlement bool `mapstructure:"exitBeforeSettlement"`
+	// MaxHoldRate максимальная ставка для удержания позиции через расчет.
+	MaxHoldRate float64 `mapstructure:"maxHoldRate"`
+	// ExitWindow время до расчета, в течение которого позиция закрывается.
+	ExitWindow time.Duration `mapstructure:"exitWindow"`
+}
+
+// FundingInfo ставка финансирования валютной пары.
+type FundingInfo struct {
+	Symbol    string
+	MarkPrice float64
+	// CurrentRate ставка последнего расчета.
+	CurrentRate float64
+	// PredictedRate ожидаемая ставка ближайшего расчета.
+	PredictedRate   float64
+	NextFundingTime time.Time
+}
+
+// PaidRate ставка, которую сторона позиции side заплатит при ближайшем
+// расчете. Отрицательное значение означает, что позиция получит выплату.
+func (f *FundingInfo) PaidRate(side TradingPosition) float64 {
+	if side == SHORT {
+		return -f.PredictedRate
+	}
+	return f.PredictedRate
+}
+
+// blocksEntry сообщает, нужно ли пропустить вход в позицию side.
+func (c FundingConfig) blocksEntry(f *FundingInfo, side TradingPosition, now time.Time) bool {
+	return c.MaxEntryRate > 0 && f.NextFundingTime.Sub(now) <= c.EntryWindow && f.PaidRate(side) > c.MaxEntryRate
+}
+
+// requiresExit сообщает, нужно ли закрыть позицию side перед расчетом.
+func (c FundingConfig) requiresExit(f *FundingInfo, side TradingPosition, now time.Time) bool {
+	return c.ExitBeforeSettlement && f.NextFundingTime.Sub(now) <= c.ExitWindow && f.PaidRate(side) > c.MaxHoldRate
+}
+
+// enabled сообщает, используется ли ставка финансирования в торговле.
+func (c FundingConfig) enabled() bool {
+	return c.MaxEntryRate > 0 || c.ExitBeforeSettlement
+}
+
+// binanceFundingInfo получает текущую и ожидаемую ставку финансирования
+// и время ближайшего расчета.
+func binanceFundingInfo(ctx context.Context, bc *BinanceClient, symbol string) (*FundingInfo, error) {
+	var index []*futures.PremiumIndex
+	err := bc.call(ctx, true, func() (err error) {
+		index, err = bc.NewPremiumIndexService().Symbol(symbol).Do(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(index) == 0 {
+		return nil, fmt.Errorf("no premium index for %s", symbol)
+	}
+
+	var rates []*futures.FundingRate
+	err = bc.call(ctx, true, func() (err error) {
+		rates, err = bc.NewFundingRateService().Symbol(symbol).Limit(1).Do(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info := &FundingInfo{
+		Symbol:          symbol,
+		NextFundingTime: time.UnixMilli(index[0].NextFundingTime),
+	}
+	info.MarkPrice, _ = strconv.ParseFloat(index[0].MarkPrice, 64)
+	info.PredictedRate, _ = strconv.ParseFloat(index[0].LastFundingRate, 64)
+	if len(rates) > 0 {
+		info.CurrentRate, _ = strconv.ParseFloat(rates[0].FundingRate, 64)
+	}
+
+	return info, nil
+}
+
+// IncomeSummary доходы и расходы по валютной паре за период. Сводка
+// только для отчета: финансирование не учитывается ни риск-менеджером,
+// ни в результате бэктеста.
+type IncomeSummary struct {
+	RealizedPnL float64
+	Funding     float64
+	Commission  float64
+}
+
+// Net итоговый результат с учетом финансирования и комиссий.
+func (s IncomeSummary) Net() float64 {
+	return s.RealizedPnL + s.Funding + s.Commission
+}
+
+// binanceIncome суммирует историю доходов по валютной паре с момента from:
+// реализованную прибыль, выплаты финансирования и комиссии.
+func binanceIncome(ctx context.Context, bc *BinanceClient, symbol string, from time.Time) (IncomeSummary, error) {
+	var summary IncomeSummary
+	startTime := from.UnixMilli()
+	// страницы пересекаются по времени последней записи: записи с одним
+	// временем могут оказаться на разных страницах
+	seen := make(map[int64]bool)
+
+	for {
+		var page []*futures.IncomeHistory
+		err := bc.call(ctx, true, func() (err error) {
+			page, err = bc.NewGetIncomeHistoryService().
+				Symbol(symbol).
+				StartTime(startTime).
+				Limit(incomePageLimit).
+				Do(ctx, bc.recvWindow())
+			return err
+		})
+		if err != nil {
+			return summary, err
+		}
+
+		added := 0
+		for _, h := range page {
+			startTime = h.Time
+			if seen[h.TranID] {
+				continue
+			}
+			seen[h.TranID] = true
+			added++
+
+			income, _ := strconv.ParseFloat(h.Income, 64)
+			switch h.IncomeType {
+			case "REALIZED_PNL":
+				summary.RealizedPnL += income
+			case "FUNDING_FEE":
+				summary.Funding += income
+			case "COMMISSION":
+				summary.Commission += income
+			}
+		}
+
+		if len(page) < incomePageLimit {
+			break
+		}
+		if added == 0 {
+			// вся страница с одним временем, дальше по нему не продвинуться
+			startTime++
+		}
+	}
+
+	return summary, nil
+}
+
+// printIncomeSummary выводит доходы и расходы за период.
+func printIncomeSummary(s IncomeSummary) {
+	fmt.Printf("Реализованная прибыль: %.4f\n", s.RealizedPnL)
+	fmt.Printf("Финансирование: %.4f\n", s.Funding)
+	fmt.Printf("Комиссии: %.4f\n", s.Commission)
+	fmt.Printf("Итого: %.4f\n", s.Net())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/adshao/go-binance/v2/futures"
+	"math"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFundingConfigGates(t *testing.T) {
+	now := time.Date(2024, 3, 5, 7, 30, 0, 0, time.UTC)
+	cfg := FundingConfig{MaxEntryRate: 0.0005, EntryWindow: time.Hour, ExitBeforeSettlement: true, MaxHoldRate: 0.0005, ExitWindow: 5 * time.Minute}
+
+	tests := []struct {
+		name string
+		cfg  FundingConfig
+		rate float64
+		// until время до ближайшего расчета
+		until     time.Duration
+		side      TradingPosition
+		wantEntry bool
+		wantExit  bool
+	}{
+		{name: "long pays before settlement", cfg: cfg, rate: 0.001, until: 3 * time.Minute, side: LONG, wantEntry: true, wantExit: true},
+		{name: "short receives", cfg: cfg, rate: 0.001, until: 3 * time.Minute, side: SHORT},
+		{name: "short pays negative rate", cfg: cfg, rate: -0.001, until: 3 * time.Minute, side: SHORT, wantEntry: true, wantExit: true},
+		{name: "entry window only", cfg: cfg, rate: 0.001, until: 30 * time.Minute, side: LONG, wantEntry: true},
+		{name: "entry window boundary", cfg: cfg, rate: 0.001, until: time.Hour, side: LONG, wantEntry: true},
+		{name: "settlement far away", cfg: cfg, rate: 0.001, until: 2 * time.Hour, side: LONG},
+		{name: "rate at limit", cfg: cfg, rate: 0.0005, until: 3 * time.Minute, side: LONG},
+		{name: "disabled", rate: 0.01, until: time.Minute, side: LONG},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &FundingInfo{PredictedRate: tt.rate, NextFundingTime: now.Add(tt.until)}
+			if got := tt.cfg.blocksEntry(f, tt.side, now); got != tt.wantEntry {
+				t.Errorf("blocksEntry = %t, want %t", got, tt.wantEntry)
+			}
+			if got := tt.cfg.requiresExit(f, tt.side, now); got != tt.wantExit {
+				t.Errorf("requiresExit = %t, want %t", got, tt.wantExit)
+			}
+		})
+	}
+}
+
+func TestBinanceIncome(t *testing.T) {
+	from := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+
+	var history []*futures.IncomeHistory
+	tranID := int64(0)
+	add := func(n int, at time.Time, incomeType, income string) {
+		for i := 0; i < n; i++ {
+			tranID++
+			history = append(history, &futures.IncomeHistory{TranID: tranID, Time: at.UnixMilli(), IncomeType: incomeType, Income: income})
+		}
+	}
+	// записи с одним временем на разных страницах
+	add(600, from, "REALIZED_PNL", "1")
+	add(600, from.Add(time.Hour), "FUNDING_FEE", "-0.01")
+	// целая страница с одним временем
+	add(incomePageLimit, from.Add(2*time.Hour), "COMMISSION", "-0.001")
+	add(1, from.Add(3*time.Hour), "REALIZED_PNL", "-50")
+	add(1, from.Add(3*time.Hour), "TRANSFER", "1000")
+
+	requests := 0
+	bc := testExchange(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		start, _ := strconv.ParseInt(r.URL.Query().Get("startTime"), 10, 64)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		var page []*futures.IncomeHistory
+		for _, h := range history {
+			if h.Time >= start && len(page) < limit {
+				page = append(page, h)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	})
+
+	s, err := binanceIncome(context.Background(), bc, "ETHUSDT", from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := IncomeSummary{RealizedPnL: 600 - 50, Funding: -6, Commission: -1}
+	if math.Abs(s.RealizedPnL-want.RealizedPnL) > 1e-6 || math.Abs(s.Funding-want.Funding) > 1e-6 || math.Abs(s.Commission-want.Commission) > 1e-6 {
+		t.Errorf("binanceIncome = %+v, want %+v", s, want)
+	}
+	// страницы с from, с 01:00, дважды с 02:00, с 02:00 + 1 мс
+	if requests != 5 {
+		t.Errorf("%d income requests, want 5", requests)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/adshao/go-binance/v2/futures"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FillRecord запись журнала исполнений ордеров бота.
+type FillRecord struct {
+	Time        time.Time               `json:"time"`
+	Symbol      string                  `json:"symbol"`
+	PositionID  string                  `json:"positionId"`
+	Action      string                  `json:"action"`
+	OrderID     int64                   `json:"orderId"`
+	Side        futures.SideType        `json:"side"`
+	Quantity    float64                 `json:"quantity"`
+	Price       float64                 `json:"price"`
+	RealizedPnL float64                 `json:"realizedPnl"`
+	Commission  float64                 `json:"commission"`
+	Status      futures.OrderStatusType `json:"status"`
+}
+
+// recordFills дописывает исполнения ордеров бота из потока данных
+// пользователя в журнал file.
+func recordFills(file string, updates []OrderUpdate, cfg *Config) error {
+	for _, u := range updates {
+		positionID, action, ok := parseClientOrderID(cfg, u.ClientOrderID)
+		if !ok || u.LastFilled == 0 {
+			continue
+		}
+		err := appendJSONL(file, FillRecord{
+			Time:        u.Time,
+			Symbol:      cfg.Symbol,
+			PositionID:  positionID,
+			Action:      action,
+			OrderID:     u.OrderID,
+			Side:        u.Side,
+			Quantity:    u.LastFilled,
+			Price:       u.LastPrice,
+			RealizedPnL: u.RealizedPnL,
+			Commission:  u.Commission,
+			Status:      u.Status,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendJSONL дописывает v строкой JSON в файл file.
+func appendJSONL(file string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/rocketlaunchr/dataframe-go"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// klinesPageLimit максимальное кол-во свечей в одном запросе к бирже.
+const klinesPageLimit = 1500
+
+// Candle закрытая свеча.
+type Candle struct {
+	OpenTime int64
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// KlineGap пропуск в истории свечей: отсутствуют свечи с временем
+// открытия в диапазоне [From, To].
+type KlineGap struct {
+	From int64
+	To   int64
+}
+
+// contains сообщает, что пропуск g целиком входит в пропуск k.
+func (k KlineGap) contains(g KlineGap) bool {
+	return k.From <= g.From && g.To <= k.To
+}
+
+// klineStep шаг времени открытия свечей. Месячные свечи открываются
+// в начале календарного месяца, поэтому их шаг считается в месяцах.
+type klineStep struct {
+	step   time.Duration
+	months int
+}
+
+// newKlineStep определяет шаг свечей интервала interval.
+func newKlineStep(interval string) (klineStep, error) {
+	step, err := intervalDuration(interval)
+	if err != nil {
+		return klineStep{}, err
+	}
+	if interval[len(interval)-1] == 'M' {
+		months, _ := strconv.Atoi(interval[:len(interval)-1])
+		return klineStep{months: months}, nil
+	}
+	return klineStep{step: step}, nil
+}
+
+// next время открытия свечи, следующей за свечой, открытой в openTime.
+func (s klineStep) next(openTime int64) int64 {
+	if s.months > 0 {
+		return time.UnixMilli(openTime).UTC().AddDate(0, s.months, 0).UnixMilli()
+	}
+	return openTime + s.step.Milliseconds()
+}
+
+// KlinesStore локальное хранилище свечей: по одному csv-файлу
+// на валютную пару и интервал.
+type KlinesStore struct {
+	Dir string
+}
+
+// NewKlinesStore создает хранилище свечей в директории dir.
+func NewKlinesStore(dir string) *KlinesStore {
+	return &KlinesStore{Dir: dir}
+}
+
+// Path возвращает путь к файлу свечей валютной пары.
+func (s *KlinesStore) Path(symbol, interval string) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("%s_%s.csv", symbol, interval))
+}
+
+// Load загружает сохраненные свечи, отсортированные по времени открытия.
+// Если файла нет, возвращает пустой список.
+func (s *KlinesStore) Load(symbol, interval string) ([]Candle, error) {
+	file, err := os.Open(s.Path(symbol, interval))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	if _, err = reader.Read(); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var candles []Candle
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c, err := parseCandleRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, c)
+	}
+
+	return mergeCandles(candles), nil
+}
+
+// GapsPath возвращает путь к файлу пропусков, которых нет в истории биржи.
+func (s *KlinesStore) GapsPath(symbol, interval string) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("%s_%s_gaps.csv", symbol, interval))
+}
+
+// LoadGaps загружает пропуски, которые не удалось заполнить с биржи.
+func (s *KlinesStore) LoadGaps(symbol, interval string) ([]KlineGap, error) {
+	data, err := os.ReadFile(s.GapsPath(symbol, interval))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var gaps []KlineGap
+	for _, record := range records {
+		if len(record) != 2 {
+			return nil, fmt.Errorf("invalid gap record: %v", record)
+		}
+		var g KlineGap
+		if g.From, err = strconv.ParseInt(record[0], 10, 64); err != nil {
+			return nil, err
+		}
+		if g.To, err = strconv.ParseInt(record[1], 10, 64); err != nil {
+			return nil, err
+		}
+		gaps = append(gaps, g)
+	}
+
+	return gaps, nil
+}
+
+// SaveGaps сохраняет пропуски, которые не удалось заполнить с биржи.
+func (s *KlinesStore) SaveGaps(symbol, interval string, gaps []KlineGap) error {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	for _, g := range gaps {
+		_ = writer.Write([]string{strconv.FormatInt(g.From, 10), strconv.FormatInt(g.To, 10)})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.GapsPath(symbol, interval), buf.Bytes(), 0o644)
+}
+
+// Save сохраняет свечи, заменяя содержимое файла.
+func (s *KlinesStore) Save(symbol, interval string, candles []Candle) error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+
+	// пишем во временный файл, чтобы не потерять историю при сбое
+	path := s.Path(symbol, interval)
+	tmp := path + ".tmp"
+	csvFile, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(csvFile)
+	_ = writer.Write([]string{"date", "open", "high", "low", "close", "volume"})
+	for _, c := range candles {
+		_ = writer.Write([]string{
+			strconv.FormatInt(c.OpenTime, 10),
+			strconv.FormatFloat(c.Open, 'f', -1, 64),
+			strconv.FormatFloat(c.High, 'f', -1, 64),
+			strconv.FormatFloat(c.Low, 'f', -1, 64),
+			strconv.FormatFloat(c.Close, 'f', -1, 64),
+			strconv.FormatFloat(c.Volume, 'f', -1, 64),
+		})
+	}
+	writer.Flush()
+
+	if err = writer.Error(); err != nil {
+		csvFile.Close()
+		return err
+	}
+	if err = csvFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Update дозагружает историю свечей с биржи: от from (если история
+// начинается позже) и от последней сохраненной свечи до текущего момента,
+// затем заполняет пропуски. Загруженное сохраняется после каждого участка,
+// поэтому при сбое следующий запуск продолжает с сохраненных свечей.
+// Пропуски, которых нет и на бирже, запоминаются и больше не загружаются.
+// Возвращает итоговый список свечей.
+func (s *KlinesStore) Update(ctx context.Context, bc *BinanceClient, symbol, interval string, from time.Time) ([]Candle, error) {
+	step, err := newKlineStep(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	candles, err := s.Load(symbol, interval)
+	if err != nil {
+		return nil, err
+	}
+	known, err := s.LoadGaps(symbol, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	// merge добавляет загруженные свечи участка и сохраняет их,
+	// даже если участок загружен не полностью
+	merge := func(fetched []Candle, err error) error {
+		if len(fetched) > 0 {
+			candles = mergeCandles(append(candles, fetched...))
+			if saveErr := s.Save(symbol, interval, candles); saveErr != nil {
+				return errors.Join(err, saveErr)
+			}
+		}
+		return err
+	}
+
+	now := time.Now()
+	if len(candles) == 0 {
+		if err = merge(downloadKlines(ctx, bc, symbol, interval, from, now)); err != nil {
+			return nil, err
+		}
+	} else {
+		first, last := candles[0].OpenTime, candles[len(candles)-1].OpenTime
+		if !from.IsZero() && from.UnixMilli() < first {
+			if err = merge(downloadKlines(ctx, bc, symbol, interval, from, time.UnixMilli(first-1))); err != nil {
+				return nil, err
+			}
+		}
+		if err = merge(downloadKlines(ctx, bc, symbol, interval, time.UnixMilli(step.next(last)), now)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, gap := range findKlineGaps(candles, step, known) {
+		if err = merge(downloadKlines(ctx, bc, symbol, interval, time.UnixMilli(gap.From), time.UnixMilli(gap.To))); err != nil {
+			return nil, err
+		}
+	}
+
+	// оставшиеся пропуски есть и в истории биржи
+	if missing := findKlineGaps(candles, step, known); len(missing) > 0 {
+		for _, g := range missing {
+			fmt.Printf("Свечей нет на бирже: с %s по %s\n",
+				time.UnixMilli(g.From).Format(time.DateTime), time.UnixMilli(g.To).Format(time.DateTime))
+		}
+		if err = s.SaveGaps(symbol, interval, append(known, missing...)); err != nil {
+			return nil, err
+		}
+	}
+
+	return candles, nil
+}
+
+// downloadKlines загружает закрытые свечи с временем открытия в диапазоне
+// [from, to], постранично двигаясь от to назад во времени. При ошибке
+// возвращает вместе с ней уже загруженные свечи.
+func downloadKlines(ctx context.Context, bc *BinanceClient, symbol, interval string, from, to time.Time) ([]Candle, error) {
+	var pages [][]Candle
+	endTime := to.UnixMilli()
+	now := time.Now().UnixMilli()
+
+	for endTime >= from.UnixMilli() {
+		var klines []*futures.Kline
+		err := bc.call(ctx, true, func() (err error) {
+			klines, err = bc.NewKlinesService().
+				Symbol(symbol).
+				Interval(interval).
+				Limit(klinesPageLimit).
+				EndTime(endTime).
+				Do(ctx)
+			return err
+		})
+		if err != nil {
+			return joinPages(pages), err
+		}
+		if len(klines) == 0 {
+			break
+		}
+
+		page := make([]Candle, 0, len(klines))
+		for _, k := range klines {
+			// последняя свеча может быть еще не закрыта
+			if k.OpenTime < from.UnixMilli() || k.OpenTime > to.UnixMilli() || k.CloseTime >= now {
+				continue
+			}
+			c, err := parseCandleRecord([]string{strconv.FormatInt(k.OpenTime, 10), k.Open, k.High, k.Low, k.Close, k.Volume})
+			if err != nil {
+				return joinPages(pages), err
+			}
+			page = append(page, c)
+		}
+		pages = append(pages, page)
+
+		if len(klines) < klinesPageLimit {
+			break
+		}
+		endTime = klines[0].OpenTime - 1
+	}
+
+	return joinPages(pages), nil
+}
+
+// joinPages собирает страницы свечей, загруженные от новых к старым,
+// в один список от старых к новым.
+func joinPages(pages [][]Candle) []Candle {
+	var candles []Candle
+	for i := len(pages) - 1; i >= 0; i-- {
+		candles = append(candles, pages[i]...)
+	}
+	return candles
+}
+
+// mergeCandles сортирует свечи по времени открытия и удаляет дубликаты,
+// оставляя более позднюю запись.
+func mergeCandles(candles []Candle) []Candle {
+	sort.SliceStable(candles, func(i, j int) bool {
+		return candles[i].OpenTime < candles[j].OpenTime
+	})
+
+	res := candles[:0]
+	for _, c := range candles {
+		if len(res) > 0 && res[len(res)-1].OpenTime == c.OpenTime {
+			res[len(res)-1] = c
+			continue
+		}
+		res = append(res, c)
+	}
+
+	return res
+}
+
+// findKlineGaps находит пропуски в отсортированном списке свечей,
+// кроме входящих в известные пропуски known.
+func findKlineGaps(candles []Candle, step klineStep, known []KlineGap) []KlineGap {
+	var gaps []KlineGap
+
+	for i := 1; i < len(candles); i++ {
+		next := step.next(candles[i-1].OpenTime)
+		if candles[i].OpenTime <= next {
+			continue
+		}
+		gap := KlineGap{From: next, To: candles[i].OpenTime - 1}
+		if !slices.ContainsFunc(known, func(k KlineGap) bool { return k.contains(gap) }) {
+			gaps = append(gaps, gap)
+		}
+	}
+
+	return gaps
+}
+
+// filterCandles оставляет свечи, открытые в диапазоне [from, to].
+// Нулевые границы не ограничивают диапазон.
+func filterCandles(candles []Candle, from, to time.Time) []Candle {
+	var res []Candle
+	for _, c := range candles {
+		if !from.IsZero() && c.OpenTime < from.UnixMilli() {
+			continue
+		}
+		if !to.IsZero() && c.OpenTime > to.UnixMilli() {
+			continue
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// candlesDataFrame строит датафрейм свечей с колонками как в writeKLinesToCsv.
+func candlesDataFrame(candles []Candle) *dataframe.DataFrame {
+	n := len(candles)
+	dates := make([]int64, n)
+	open, high, low, closes, volume := make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)
+
+	for i, c := range candles {
+		dates[i] = c.OpenTime
+		open[i], high[i], low[i], closes[i], volume[i] = c.Open, c.High, c.Low, c.Close, c.Volume
+	}
+
+	return dataframe.NewDataFrame(
+		dataframe.NewSeriesInt64("date", nil, dates),
+		dataframe.NewSeriesFloat64("open", nil, open),
+		dataframe.NewSeriesFloat64("high", nil, high),
+		dataframe.NewSeriesFloat64("low", nil, low),
+		dataframe.NewSeriesFloat64("close", nil, closes),
+		dataframe.NewSeriesFloat64("volume", nil, volume),
+	)
+}
+
+func parseCandleRecord(record []string) (Candle, error) {
+	if len(record) < 6 {
+		return Candle{}, fmt.Errorf("invalid kline record: %v", record)
+	}
+
+	var c Candle
+	var err error
+	if c.OpenTime, err = strconv.ParseInt(record[0], 10, 64); err != nil {
+		return c, err
+	}
+
+	fields := []*float64{&c.Open, &c.High, &c.Low, &c.Close, &c.Volume}
+	for i, f := range fields {
+		if *f, err = strconv.ParseFloat(record[i+1], 64); err != nil {
+			return c, err
+		}
+	}
+
+	return c, nil
+}
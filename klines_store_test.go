@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/adshao/go-binance/v2/futures"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testExchange клиент биржи, запросы которого обслуживает handler.
+// Время с сервером не синхронизируется, лимиты и повторы отключены.
+func testExchange(t *testing.T, handler http.HandlerFunc) *BinanceClient {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	bc := &BinanceClient{
+		Client:       futures.NewClient("key", "secret"),
+		breaker:      NewCircuitBreaker(0, 0),
+		limiter:      NewRateLimiter(RateLimitConfig{}, nil),
+		timeSyncedAt: time.Now(),
+	}
+	bc.BaseURL = srv.URL
+	bc.HTTPClient = &http.Client{Transport: bc.limiter}
+	return bc
+}
+
+// writeAPIError отвечает ошибкой биржи с кодом code.
+func writeAPIError(w http.ResponseWriter, code int) {
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]any{"code": code, "msg": "test error"})
+}
+
+// klinesHandler отдает минутные свечи, открытые в opens, как эндпоинт
+// /fapi/v1/klines: последние limit свечей, открытых не позже endTime.
+func klinesHandler(opens []int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endTime, _ := strconv.ParseInt(r.URL.Query().Get("endTime"), 10, 64)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+		n, _ := slices.BinarySearch(opens, endTime+1)
+		page := opens[max(0, n-limit):n]
+
+		klines := make([][]any, 0, len(page))
+		for _, open := range page {
+			klines = append(klines, []any{open, "1", "2", "0.5", "1.5", "10", open + time.Minute.Milliseconds() - 1, "15", 3, "5", "7", "0"})
+		}
+		_ = json.NewEncoder(w).Encode(klines)
+	}
+}
+
+func TestNewKlineStep(t *testing.T) {
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		interval string
+		from     time.Time
+		want     time.Time
+	}{
+		{"15m", jan, jan.Add(15 * time.Minute)},
+		{"4h", jan, jan.Add(4 * time.Hour)},
+		{"1w", jan, jan.AddDate(0, 0, 7)},
+		// месячные свечи идут по календарным месяцам, а не по 30 дней
+		{"1M", jan, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"1M", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"3M", time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.interval+" from "+tt.from.Format(time.DateOnly), func(t *testing.T) {
+			step, err := newKlineStep(tt.interval)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := step.next(tt.from.UnixMilli()); got != tt.want.UnixMilli() {
+				t.Errorf("next = %s, want %s", time.UnixMilli(got).UTC(), tt.want)
+			}
+		})
+	}
+
+	if _, err := newKlineStep("1y"); err == nil {
+		t.Error("expected error for unsupported interval")
+	}
+}
+
+func TestMergeCandles(t *testing.T) {
+	got := mergeCandles([]Candle{
+		{OpenTime: 3, Close: 3},
+		{OpenTime: 1, Close: 1},
+		{OpenTime: 2, Close: 2},
+		// повторно загруженная свеча заменяет сохраненную
+		{OpenTime: 1, Close: 10},
+	})
+
+	want := []Candle{{OpenTime: 1, Close: 10}, {OpenTime: 2, Close: 2}, {OpenTime: 3, Close: 3}}
+	if !slices.Equal(got, want) {
+		t.Errorf("mergeCandles = %v, want %v", got, want)
+	}
+}
+
+func TestFindKlineGaps(t *testing.T) {
+	hour := time.Hour.Milliseconds()
+	hourly, _ := newKlineStep("1h")
+	monthly, _ := newKlineStep("1M")
+	month := func(m time.Month) int64 {
+		return time.Date(2024, m, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	}
+	candles := func(opens ...int64) []Candle {
+		res := make([]Candle, len(opens))
+		for i, open := range opens {
+			res[i].OpenTime = open
+		}
+		return res
+	}
+
+	tests := []struct {
+		name    string
+		candles []Candle
+		step    klineStep
+		known   []KlineGap
+		want    []KlineGap
+	}{
+		{"no gaps", candles(0, hour, 2*hour), hourly, nil, nil},
+		{"missing hours", candles(0, hour, 4*hour, 5*hour, 7*hour), hourly, nil, []KlineGap{{2 * hour, 4*hour - 1}, {6 * hour, 7*hour - 1}}},
+		{"known gap", candles(0, hour, 4*hour, 5*hour, 7*hour), hourly, []KlineGap{{2 * hour, 4*hour - 1}}, []KlineGap{{6 * hour, 7*hour - 1}}},
+		{"months of different length", candles(month(1), month(2), month(3), month(4)), monthly, nil, nil},
+		{"missing month", candles(month(1), month(3)), monthly, nil, []KlineGap{{month(2), month(3) - 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findKlineGaps(tt.candles, tt.step, tt.known); !slices.Equal(got, tt.want) {
+				t.Errorf("findKlineGaps = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKlinesStoreUpdate(t *testing.T) {
+	minute := time.Minute.Milliseconds()
+	now := time.Now().Truncate(time.Minute)
+	from := now.Add(-2000 * time.Minute)
+
+	// свечей с 100-й по 109-ю минуту нет на бирже
+	hole := KlineGap{From: from.UnixMilli() + 100*minute, To: from.UnixMilli() + 110*minute - 1}
+	var opens []int64
+	for open := from.UnixMilli(); open <= now.UnixMilli(); open += minute {
+		if open < hole.From || open > hole.To {
+			opens = append(opens, open)
+		}
+	}
+
+	var requests []int64
+	fail := false
+	serve := klinesHandler(opens)
+	bc := testExchange(t, func(w http.ResponseWriter, r *http.Request) {
+		endTime, _ := strconv.ParseInt(r.URL.Query().Get("endTime"), 10, 64)
+		requests = append(requests, endTime)
+		if fail && len(requests) > 1 {
+			writeAPIError(w, -1121)
+			return
+		}
+		serve(w, r)
+	})
+
+	store := NewKlinesStore(t.TempDir())
+	ctx := context.Background()
+
+	// загрузка прервалась на второй странице: первая уже сохранена
+	fail = true
+	if _, err := store.Update(ctx, bc, "ETHUSDT", "1m", from); err == nil {
+		t.Fatal("expected error from the second page")
+	}
+	saved, err := store.Load("ETHUSDT", "1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) < klinesPageLimit-1 {
+		t.Fatalf("saved %d candles after failure, want the first page", len(saved))
+	}
+
+	// следующий запуск догружает только более старые свечи
+	fail, requests = false, nil
+	candles, err := store.Update(ctx, bc, "ETHUSDT", "1m", from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if candles[0].OpenTime != from.UnixMilli() || requests[0] != saved[0].OpenTime-1 {
+		t.Errorf("history starts at %d after request up to %d, want %d after request up to %d",
+			candles[0].OpenTime, requests[0], from.UnixMilli(), saved[0].OpenTime-1)
+	}
+	gaps, err := store.LoadGaps("ETHUSDT", "1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(gaps, []KlineGap{hole}) {
+		t.Fatalf("gaps = %v, want %v", gaps, []KlineGap{hole})
+	}
+
+	// известный пропуск больше не запрашивается
+	requests = nil
+	if _, err = store.Update(ctx, bc, "ETHUSDT", "1m", from); err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(requests, hole.To) {
+		t.Errorf("known gap requested again: %v", requests)
+	}
+}
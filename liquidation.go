@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/adshao/go-binance/v2/futures"
+	"math"
+	"strconv"
+)
+
+// LiquidationConfig контроль расстояния до ликвидации и уровня маржи.
+type LiquidationConfig struct {
+	// MinDistance минимальное расстояние от маркировочной цены до цены
+	// ликвидации в долях, при меньшем отправляется предупреждение. 0 - не проверять.
+	MinDistance float64 `mapstructure:"minDistance"`
+	// ReduceFraction доля позиции, закрываемая при расстоянии меньше
+	// MinDistance. 0 - не сокращать позицию.
+	ReduceFraction float64 `mapstructure:"reduceFraction"`
+	// MaxMarginRatio уровень маржи счета (поддерживающая маржа к марже
+	// счета), при превышении которого отправляется предупреждение. 0 - не проверять.
+	MaxMarginRatio float64 `mapstructure:"maxMarginRatio"`
+	// MaintMarginRate ставка поддерживающей маржи для оценки цены
+	// ликвидации до входа при изолированной марже.
+	MaintMarginRate float64 `mapstructure:"maintMarginRate"`
+}
+
+// MarginRatio уровень маржи счета: отношение поддерживающей маржи
+// к марже счета. При 1 наступает ликвидация.
+func (p *OpenedPosition) MarginRatio() float64 {
+	if p.MarginBalance <= 0 {
+		return 0
+	}
+	return p.MaintMargin / p.MarginBalance
+}
+
+// LiquidationDistance расстояние от маркировочной цены до цены ликвидации
+// в долях от маркировочной цены. Если биржа не рассчитала цену
+// ликвидации, возвращает +Inf.
+func (l PositionLeg) LiquidationDistance() float64 {
+	if l.LiquidationPrice <= 0 || l.MarkPrice <= 0 {
+		return math.Inf(1)
+	}
+	return math.Abs(l.MarkPrice-l.LiquidationPrice) / l.MarkPrice
+}
+
+// checkStopBeforeLiquidation проверяет, что стоп-лосс stopPercent сработает
+// раньше ликвидации. Расстояние до ликвидации оценивается для
+// изолированной маржи: 1/плечо минус ставка поддерживающей маржи. При
+// кросс-марже ликвидацию определяет баланс всего счета, до входа она не
+// оценивается: после входа ее контролирует checkLiquidation по цене биржи.
+func (c LiquidationConfig) checkStopBeforeLiquidation(pos *OpenedPosition, stopPercent float64) error {
+	if !pos.Isolated || pos.Leverage <= 0 {
+		return nil
+	}
+	distance := 1/pos.Leverage - c.MaintMarginRate
+	if stopPercent >= distance {
+		return fmt.Errorf("stop %.2f%% lies beyond estimated liquidation %.2f%% at leverage %.0f",
+			stopPercent*100, distance*100, pos.Leverage)
+	}
+	return nil
+}
+
+// checkLiquidation предупреждает о приближении ликвидации и уровне маржи
+// счета выше допустимого, при необходимости сокращает позицию. Стороны,
+// открытые вручную, не сокращаются.
+// Предупреждение отправляется один раз, пока показатель не вернется в норму.
+func checkLiquidation(ctx context.Context, bc *BinanceClient, pos *OpenedPosition, state *TradeState, cfg *Config) error {
+	lc := cfg.Liquidation
+
+	if lc.MaxMarginRatio > 0 {
+		ratio := pos.MarginRatio()
+		if ratio >= lc.MaxMarginRatio && !state.highMarginRatio {
+			alert(fmt.Sprintf("Уровень маржи %.2f%% превысил %.2f%%", ratio*100, lc.MaxMarginRatio*100))
+		}
+		state.highMarginRatio = ratio >= lc.MaxMarginRatio
+	}
+
+	if lc.MinDistance <= 0 {
+		return nil
+	}
+	for _, leg := range pos.Legs {
+		ls := state.Leg(leg.Side)
+		distance := leg.LiquidationDistance()
+		if distance >= lc.MinDistance {
+			ls.NearLiquidation = false
+			continue
+		}
+		if ls.NearLiquidation {
+			continue
+		}
+		ls.NearLiquidation = true
+
+		alert(fmt.Sprintf("%s %s: до ликвидации %.2f%%, цена ликвидации %f, маркировочная цена %f",
+			cfg.Symbol, leg.Side, distance*100, leg.LiquidationPrice, leg.MarkPrice))
+		if ls.Manual {
+			continue
+		}
+		if lc.ReduceFraction > 0 {
+			q := leg.Amount * lc.ReduceFraction
+			fmt.Printf("Сокращение позиции %s на %f из-за близости ликвидации\n", leg.Side, q)
+			clientID := clientOrderID(cfg, ls.PositionID, ActionLiquidation)
+			if err := binanceClosePosition(ctx, bc, leg.Side, q, pos.Hedge, clientID, cfg.Execution.Stop, cfg); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// binancePositionRisk дополняет открытые стороны позиции ценой ликвидации
+// и маркировочной ценой.
+func binancePositionRisk(ctx context.Context, bc *BinanceClient, pos *OpenedPosition, symbol string) error {
+	var risks []*futures.PositionRisk
+	err := bc.call(ctx, true, func() (err error) {
+		risks, err = bc.NewGetPositionRiskService().Symbol(symbol).Do(ctx, bc.recvWindow())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range risks {
+		amount, _ := strconv.ParseFloat(r.PositionAmt, 64)
+		if r.Symbol != symbol || amount == 0 {
+			continue
+		}
+		if leg := pos.Leg(positionRiskSide(r, amount)); leg != nil {
+			leg.LiquidationPrice, _ = strconv.ParseFloat(r.LiquidationPrice, 64)
+			leg.MarkPrice, _ = strconv.ParseFloat(r.MarkPrice, 64)
+		}
+	}
+
+	return nil
+}
+
+// binanceLiveAmount текущий объем стороны позиции position на бирже.
+func binanceLiveAmount(ctx context.Context, bc *BinanceClient, symbol string, position TradingPosition) (float64, error) {
+	var risks []*futures.PositionRisk
+	err := bc.call(ctx, true, func() (err error) {
+		risks, err = bc.NewGetPositionRiskService().Symbol(symbol).Do(ctx, bc.recvWindow())
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, r := range risks {
+		amount, _ := strconv.ParseFloat(r.PositionAmt, 64)
+		if r.Symbol == symbol && amount != 0 && positionRiskSide(r, amount) == position {
+			return math.Abs(amount), nil
+		}
+	}
+
+	return 0, nil
+}
+
+// positionRiskSide сторона позиции с объемом amount.
+func positionRiskSide(r *futures.PositionRisk, amount float64) TradingPosition {
+	if r.PositionSide == string(futures.PositionSideTypeShort) || (r.PositionSide == string(futures.PositionSideTypeBoth) && amount < 0) {
+		return SHORT
+	}
+	return LONG
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestCheckStopBeforeLiquidation(t *testing.T) {
+	c := LiquidationConfig{MaintMarginRate: 0.005}
+
+	tests := []struct {
+		name    string
+		pos     OpenedPosition
+		stop    float64
+		wantErr bool
+	}{
+		{"stop before liquidation", OpenedPosition{Isolated: true, Leverage: 20}, 0.02, false},
+		// при плече 20 ликвидация на расстоянии 4.5%
+		{"stop beyond liquidation", OpenedPosition{Isolated: true, Leverage: 20}, 0.05, true},
+		{"cross margin not estimated", OpenedPosition{Leverage: 20}, 0.1, false},
+		{"unknown leverage", OpenedPosition{Isolated: true}, 0.1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := c.checkStopBeforeLiquidation(&tt.pos, tt.stop); (err != nil) != tt.wantErr {
+				t.Errorf("checkStopBeforeLiquidation error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckLiquidationManualLeg(t *testing.T) {
+	bc := testExchange(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+	})
+	cfg := &Config{Symbol: "ETHUSDT", Liquidation: LiquidationConfig{MinDistance: 0.05, ReduceFraction: 0.5}}
+	pos := &OpenedPosition{Legs: []PositionLeg{{Side: LONG, Amount: 0.1, EntryPrice: 100, MarkPrice: 100, LiquidationPrice: 97}}}
+	state := NewTradeState(nil)
+	state.Sync(pos, 0.01)
+	state.Leg(LONG).Manual = true
+
+	// сторона, открытая вручную, только отмечается предупреждением
+	if err := checkLiquidation(context.Background(), bc, pos, state, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !state.Leg(LONG).NearLiquidation {
+		t.Error("near liquidation alert not recorded")
+	}
+}
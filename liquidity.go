@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/adshao/go-binance/v2/futures"
+	"math"
+	"slices"
+)
+
+// depthLimits допустимые глубины стакана в запросе к бирже.
+var depthLimits = []int{5, 10, 20, 50, 100, 500, 1000}
+
+// LiquidityConfig проверка ликвидности стакана перед входом в позицию.
+// Доли задаются от цены: 0.001 = 0.1%. Проверка включается, если задан
+// MaxSpread или MaxSlippage.
+type LiquidityConfig struct {
+	// DepthLimit кол-во уровней стакана: 5, 10, 20, 50, 100, 500 или 1000.
+	DepthLimit int `mapstructure:"depthLimit"`
+	// MaxSpread максимальный спред между лучшими ценами от средней цены.
+	// 0 - не проверять.
+	MaxSpread float64 `mapstructure:"maxSpread"`
+	// MaxSlippage максимальное отклонение средней цены исполнения
+	// от лучшей цены стакана. 0 - не проверять.
+	MaxSlippage float64 `mapstructure:"maxSlippage"`
+	// ShrinkToFit уменьшать объем входа до допустимого проскальзывания
+	// вместо пропуска входа.
+	ShrinkToFit bool `mapstructure:"shrinkToFit"`
+	// QuantityStep шаг кол-ва, до которого округляется уменьшенный объем.
+	QuantityStep float64 `mapstructure:"quantityStep"`
+}
+
+// Validate проверяет настройки ликвидности.
+func (c LiquidityConfig) Validate() error {
+	var errs []error
+	if !slices.Contains(depthLimits, c.DepthLimit) {
+		errs = append(errs, fmt.Errorf("liquidity.depthLimit must be one of %v, got %d", depthLimits, c.DepthLimit))
+	}
+	if c.MaxSpread < 0 || c.MaxSpread >= 1 || c.MaxSlippage < 0 || c.MaxSlippage >= 1 {
+		errs = append(errs, errors.New("liquidity.maxSpread and liquidity.maxSlippage must be between 0 and 1"))
+	}
+	if c.QuantityStep <= 0 {
+		errs = append(errs, errors.New("liquidity.quantityStep must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// enabled сообщает, проверяется ли ликвидность перед входом.
+func (c LiquidityConfig) enabled() bool {
+	return c.MaxSpread > 0 || c.MaxSlippage > 0
+}
+
+// BookLevel уровень стакана.
+type BookLevel struct {
+	Price    float64
+	Quantity float64
+}
+
+// OrderBook снимок стакана: Bids по убыванию цены, Asks по возрастанию.
+type OrderBook struct {
+	Bids []BookLevel
+	Asks []BookLevel
+}
+
+// Spread спред между лучшими ценами в долях от средней цены.
+func (b *OrderBook) Spread() float64 {
+	if len(b.Bids) == 0 || len(b.Asks) == 0 {
+		return math.Inf(1)
+	}
+	bid, ask := b.Bids[0].Price, b.Asks[0].Price
+	return (ask - bid) / ((ask + bid) / 2)
+}
+
+// side уровни, по которым исполнится вход в позицию position.
+func (b *OrderBook) side(position TradingPosition) []BookLevel {
+	if position == LONG {
+		return b.Asks
+	}
+	return b.Bids
+}
+
+// estimateFill средняя цена исполнения quantity по уровням levels
+// и кол-во, которое стакан может исполнить.
+func estimateFill(levels []BookLevel, quantity float64) (avgPrice, filled float64) {
+	var cost float64
+	for _, l := range levels {
+		q := math.Min(l.Quantity, quantity-filled)
+		cost += q * l.Price
+		filled += q
+		if filled >= quantity {
+			break
+		}
+	}
+	if filled == 0 {
+		return 0, 0
+	}
+	return cost / filled, filled
+}
+
+// maxQuantity наибольшее кол-во, средняя цена покупки (buy) или продажи
+// которого отклоняется от лучшей цены levels не больше maxSlippage.
+func maxQuantity(levels []BookLevel, buy bool, maxSlippage float64) float64 {
+	if len(levels) == 0 {
+		return 0
+	}
+	best := levels[0].Price
+	limit := best * (1 - maxSlippage)
+	if buy {
+		limit = best * (1 + maxSlippage)
+	}
+
+	var cost, filled float64
+	for _, l := range levels {
+		if (buy && l.Price > limit) || (!buy && l.Price < limit) {
+			// часть уровня, при которой средняя цена достигает limit; если
+			// уровня на нее не хватает, средняя цена достигнет limit глубже
+			if part := (limit*filled - cost) / (l.Price - limit); part < l.Quantity {
+				return filled + math.Max(0, part)
+			}
+		}
+		cost += l.Quantity * l.Price
+		filled += l.Quantity
+	}
+	return filled
+}
+
+// FillEstimate оценка исполнения входа по стакану.
+type FillEstimate struct {
+	AvgPrice float64
+	Slippage float64
+	Spread   float64
+	// Quantity допустимый объем входа, 0 - вход нужно пропустить.
+	Quantity float64
+	// Reason причина пропуска или уменьшения объема.
+	Reason string
+}
+
+// estimateEntry оценивает вход в позицию position на quantity по стакану
+// и определяет допустимый объем входа.
+func (c LiquidityConfig) estimateEntry(book *OrderBook, position TradingPosition, quantity float64) FillEstimate {
+	est := FillEstimate{Spread: book.Spread(), Quantity: quantity}
+	levels := book.side(position)
+	if len(levels) == 0 {
+		est.Quantity, est.Reason = 0, "стакан пуст"
+		return est
+	}
+	if c.MaxSpread > 0 && est.Spread > c.MaxSpread {
+		est.Quantity = 0
+		est.Reason = fmt.Sprintf("спред %.3f%% выше %.3f%%", est.Spread*100, c.MaxSpread*100)
+		return est
+	}
+
+	best := levels[0].Price
+	avg, filled := estimateFill(levels, quantity)
+	est.AvgPrice = avg
+	est.Slippage = math.Abs(avg-best) / best
+	if c.MaxSlippage <= 0 || (filled >= quantity && est.Slippage <= c.MaxSlippage) {
+		return est
+	}
+
+	if filled < quantity {
+		est.Reason = fmt.Sprintf("в %d уровнях стакана только %f", len(levels), filled)
+	} else {
+		est.Reason = fmt.Sprintf("ожидаемое проскальзывание %.3f%% выше %.3f%%", est.Slippage*100, c.MaxSlippage*100)
+	}
+	if !c.ShrinkToFit {
+		est.Quantity = 0
+		return est
+	}
+
+	q := math.Min(maxQuantity(levels, position == LONG, c.MaxSlippage), filled)
+	// поправка на погрешность деления, чтобы 0.03/0.001 не округлилось до 29
+	est.Quantity = math.Floor(q/c.QuantityStep+1e-9) * c.QuantityStep
+	if est.Quantity > 0 {
+		est.AvgPrice, _ = estimateFill(levels, est.Quantity)
+		est.Slippage = math.Abs(est.AvgPrice-best) / best
+	}
+	return est
+}
+
+// binanceOrderBook получает снимок стакана глубиной limit.
+func binanceOrderBook(ctx context.Context, bc *BinanceClient, symbol string, limit int) (*OrderBook, error) {
+	var res *futures.DepthResponse
+	err := bc.call(ctx, true, func() (err error) {
+		res, err = bc.NewDepthService().Symbol(symbol).Limit(limit).Do(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	book := &OrderBook{}
+	for _, b := range res.Bids {
+		price, quantity, err := b.Parse()
+		if err != nil {
+			return nil, err
+		}
+		book.Bids = append(book.Bids, BookLevel{Price: price, Quantity: quantity})
+	}
+	for _, a := range res.Asks {
+		price, quantity, err := a.Parse()
+		if err != nil {
+			return nil, err
+		}
+		book.Asks = append(book.Asks, BookLevel{Price: price, Quantity: quantity})
+	}
+
+	return book, nil
+}
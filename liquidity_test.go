@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// testBook стакан с лучшими ценами 100 и 101.
+func testBook() *OrderBook {
+	return &OrderBook{
+		Bids: []BookLevel{{100, 1}, {99.9, 2}, {99.5, 5}},
+		Asks: []BookLevel{{101, 1}, {101.1, 2}, {101.5, 5}},
+	}
+}
+
+func TestEstimateFill(t *testing.T) {
+	asks := testBook().Asks
+
+	tests := []struct {
+		name       string
+		quantity   float64
+		wantPrice  float64
+		wantFilled float64
+	}{
+		{"first level", 0.5, 101, 0.5},
+		{"two levels", 2, (101 + 101.1) / 2, 2},
+		{"all levels", 8, (101 + 2*101.1 + 5*101.5) / 8, 8},
+		{"deeper than book", 10, (101 + 2*101.1 + 5*101.5) / 8, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price, filled := estimateFill(asks, tt.quantity)
+			if math.Abs(price-tt.wantPrice) > 1e-9 || math.Abs(filled-tt.wantFilled) > 1e-9 {
+				t.Errorf("estimateFill(%f) = %f, %f, want %f, %f", tt.quantity, price, filled, tt.wantPrice, tt.wantFilled)
+			}
+		})
+	}
+}
+
+func TestMaxQuantity(t *testing.T) {
+	book := testBook()
+
+	tests := []struct {
+		name        string
+		levels      []BookLevel
+		buy         bool
+		maxSlippage float64
+	}{
+		{"buy within first level", book.Asks, true, 0.0001},
+		{"buy through levels", book.Asks, true, 0.002},
+		{"sell through levels", book.Bids, false, 0.002},
+		{"whole book", book.Asks, true, 0.1},
+		// средняя цена не достигает предела на тонком уровне
+		{"buy through thin level", []BookLevel{{100, 1}, {100.5, 0.1}, {101, 5}}, true, 0.002},
+		{"sell through thin level", []BookLevel{{100, 1}, {99.5, 0.1}, {99, 5}}, false, 0.002},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := maxQuantity(tt.levels, tt.buy, tt.maxSlippage)
+			if q <= 0 {
+				t.Fatalf("maxQuantity = %f", q)
+			}
+			// средняя цена максимального объема на границе допустимого
+			avg, _ := estimateFill(tt.levels, q)
+			if slip := math.Abs(avg-tt.levels[0].Price) / tt.levels[0].Price; slip > tt.maxSlippage+1e-9 {
+				t.Errorf("slippage of %f is %f, limit %f", q, slip, tt.maxSlippage)
+			}
+			if _, filled := estimateFill(tt.levels, math.Inf(1)); q < filled-1e-9 {
+				// объем чуть больше уже превышает проскальзывание
+				avg, _ = estimateFill(tt.levels, q+0.01)
+				if slip := math.Abs(avg-tt.levels[0].Price) / tt.levels[0].Price; slip <= tt.maxSlippage {
+					t.Errorf("maxQuantity %f is not maximal: %f has slippage %f", q, q+0.01, slip)
+				}
+			}
+		})
+	}
+
+	if q := maxQuantity(nil, true, 0.01); q != 0 {
+		t.Errorf("maxQuantity of empty book = %f", q)
+	}
+}
+
+func TestEstimateEntry(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          LiquidityConfig
+		book         *OrderBook
+		position     TradingPosition
+		quantity     float64
+		wantQuantity float64
+	}{
+		{
+			name:         "fits",
+			cfg:          LiquidityConfig{MaxSpread: 0.02, MaxSlippage: 0.002, QuantityStep: 0.001},
+			book:         testBook(),
+			position:     LONG,
+			quantity:     1,
+			wantQuantity: 1,
+		},
+		{
+			name:     "spread too wide",
+			cfg:      LiquidityConfig{MaxSpread: 0.001, QuantityStep: 0.001},
+			book:     testBook(),
+			position: LONG,
+			quantity: 1,
+		},
+		{
+			name:     "slippage too high",
+			cfg:      LiquidityConfig{MaxSlippage: 0.001, QuantityStep: 0.001},
+			book:     testBook(),
+			position: LONG,
+			quantity: 5,
+		},
+		{
+			// 1 по 101, 2 по 101.1 и 0.258 по 101.5 - средняя 101.101
+			name:         "shrink to fit",
+			cfg:          LiquidityConfig{MaxSlippage: 0.001, ShrinkToFit: true, QuantityStep: 0.001},
+			book:         testBook(),
+			position:     LONG,
+			quantity:     5,
+			wantQuantity: 3.258,
+		},
+		{
+			// 1 по 100, 2 по 99.9 и 0.25 по 99.5 - средняя 99.9
+			name:         "short walks bids",
+			cfg:          LiquidityConfig{MaxSlippage: 0.001, ShrinkToFit: true, QuantityStep: 0.001},
+			book:         testBook(),
+			position:     SHORT,
+			quantity:     5,
+			wantQuantity: 3.25,
+		},
+		{
+			name:     "empty side",
+			cfg:      LiquidityConfig{MaxSlippage: 0.001, QuantityStep: 0.001},
+			book:     &OrderBook{Bids: testBook().Bids},
+			position: LONG,
+			quantity: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			est := tt.cfg.estimateEntry(tt.book, tt.position, tt.quantity)
+			if math.Abs(est.Quantity-tt.wantQuantity) > 1e-9 {
+				t.Errorf("Quantity = %f (%s), want %f", est.Quantity, est.Reason, tt.wantQuantity)
+			}
+			if est.Quantity < tt.quantity && est.Reason == "" {
+				t.Error("no reason for reduced entry")
+			}
+		})
+	}
+}
+
+func TestOrderBookSpread(t *testing.T) {
+	if got, want := testBook().Spread(), 1/100.5; math.Abs(got-want) > 1e-12 {
+		t.Errorf("Spread = %f, want %f", got, want)
+	}
+	if got := (&OrderBook{}).Spread(); !math.IsInf(got, 1) {
+		t.Errorf("Spread of empty book = %f", got)
+	}
+}
@@ -3,176 +3,443 @@ package main
 import (
 	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"github.com/adshao/go-binance/v2/futures"
 	"github.com/rocketlaunchr/dataframe-go"
-	"github.com/wcharczuk/go-chart/v2"
 	"gonum.org/v1/gonum/mat"
 	"gonum.org/v1/gonum/stat"
 	"log"
 	"math"
 	"os"
-	"os/signal"
 	"strconv"
-	"syscall"
 	"time"
 )
 
 func main() {
-	ctx := context.Background()
+	if err := runCLI(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
 
-	doneChan := make(chan int, 1)
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+// TradingSummary итоги торговой сессии.
+type TradingSummary struct {
+	Started      time.Time
+	Ticks        int
+	Errors       int
+	MissedTicks  int
+	StartBalance float64
+}
 
-	profits := [][]int{
-		{20, 1}, {40, 1}, {60, 2}, {80, 2},
-		{100, 2}, {150, 1}, {200, 1}, {200, 0},
+// streamSettleDelay пауза после события потока данных пользователя перед
+// сопровождением позиции.
+const streamSettleDelay = time.Second
+
+// startTrading торгует до отмены ctx по расписанию Scheduler. Новые позиции
+// открываются только в окна торговли календаря. Начатый шаг стратегии
+// всегда доводится до конца, отмена прерывает только ожидание следующего шага.
+// Завершение ордеров бота и изменения позиции из потока данных пользователя
+// stream запускают сопровождение позиции сразу, не дожидаясь шага; stream
+// может быть nil.
+func startTrading(ctx context.Context, bc *BinanceClient, state *TradeState, stream *UserStream, cfg *Config, rm *RiskManager, calendar *TradingCalendar, summary *TradingSummary) error {
+	sched, err := NewScheduler(cfg.Interval, cfg.Schedule)
+	if err != nil {
+		return err
 	}
-	copyProfitsArr := make([][]int, len(profits))
-	copy(copyProfitsArr, profits)
+	tickCtx := context.WithoutCancel(ctx)
 
-	cfg := MustLoadConfig("config")
+	for {
+		tick := sched.Next(time.Now())
+		woke, ok := waitTick(ctx, time.Until(tick.At), stream.Updates())
+		if !ok {
+			return nil
+		}
+		if woke {
+			// частичные исполнения приходят пачкой, они обрабатываются вместе
+			if !sleepContext(ctx, streamSettleDelay) {
+				return nil
+			}
+			if applyStreamUpdates(state, stream, cfg) {
+				handleTradeError(ctx, bc, runTrade(tickCtx, bc, state, cfg, rm, false), summary)
+			}
+			continue
+		}
+		sched.Done(&tick)
 
-	futures.UseTestnet = true
-	bc := futures.NewClient(cfg.BinanceAPIKey, cfg.BinanceAPISecret)
+		if tick.Missed > 0 {
+			summary.MissedTicks += tick.Missed
+			fmt.Printf("Пропущено закрытий свечей: %d\n", tick.Missed)
+		}
+		if tick.CandleClose {
+			fmt.Println("Закрылась свеча:", tick.Close.Local().Format("15:04:05"))
+		}
+
+		evaluateEntry := tick.CandleClose
+		if allowed, reason := calendar.EntryAllowed(time.Now()); evaluateEntry && !allowed {
+			fmt.Println("Новые позиции не открываются:", reason)
+			evaluateEntry = false
+		}
+
+		summary.Ticks++
+		applyStreamUpdates(state, stream, cfg)
+		handleTradeError(ctx, bc, runTrade(tickCtx, bc, state, cfg, rm, evaluateEntry), summary)
+	}
+}
+
+// applyStreamUpdates записывает исполнения из потока данных пользователя
+// в журнал и учитывает завершенные ордера в состоянии. Возвращает true,
+// если позицию нужно сопровождать, не дожидаясь шага.
+func applyStreamUpdates(state *TradeState, stream *UserStream, cfg *Config) bool {
+	updates, moved := stream.TakeUpdates()
+	if cfg.JournalFile != "" {
+		if err := recordFills(cfg.JournalFile, updates, cfg); err != nil {
+			log.Println("journal:", err)
+		}
+	}
+	applied := state.ApplyOrderUpdates(updates, cfg)
+	return applied || (moved && (state.HasLegs() || stream.HasPosition()))
+}
 
-	go startTrading(ctx, bc, profits, copyProfitsArr, cfg, doneChan)
+// runTrade выполняет шаг торговли и сохраняет состояние.
+func runTrade(ctx context.Context, bc *BinanceClient, state *TradeState, cfg *Config, rm *RiskManager, evaluateEntry bool) error {
+	err := Trade(ctx, bc, state, cfg, rm, evaluateEntry)
+	if saveErr := state.Save(); saveErr != nil {
+		log.Println("save trade state:", saveErr)
+	}
+	return err
+}
 
-	go gracefulShutdown(doneChan, sigChan)
+// handleTradeError учитывает ошибку шага торговли и приостанавливает
+// торговлю при исчерпании лимитов или разомкнутом предохранителе.
+func handleTradeError(ctx context.Context, bc *BinanceClient, err error, summary *TradingSummary) {
+	if err == nil {
+		return
+	}
+	summary.Errors++
+	log.Println(err)
+	var rlErr *RateLimitError
+	if errors.As(err, &rlErr) {
+		fmt.Println("Лимит запросов исчерпан, торговля приостановлена до:", rlErr.Until.Local().Format("15:04:05"))
+		sleepContext(ctx, time.Until(rlErr.Until))
+		return
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		// торговля на паузе, пока предохранитель разомкнут
+		until := bc.Breaker().OpenUntil()
+		fmt.Println("Торговля приостановлена до:", until.Local().Format("15:04:05"))
+		sleepContext(ctx, time.Until(until))
+	}
+}
 
-	<-doneChan
+// waitTick ждет d, отмены ctx или события wake. woke - ожидание прервано
+// событием, ok - false, если ctx отменен.
+func waitTick(ctx context.Context, d time.Duration, wake <-chan struct{}) (woke, ok bool) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false, false
+	case <-timer.C:
+		return false, true
+	case <-wake:
+		return true, true
+	}
 }
 
-func startTrading(ctx context.Context, bc *futures.Client, profits, cpProfits [][]int, cfg *Config, doneChan chan int) {
-	defer close(doneChan)
+// sleepContext ждет d или отмены ctx. Возвращает false, если ctx отменен.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
 
-	startTime := time.Now()
-	timeOut := startTime.Add(time.Hour * 12)
-	errCounter := 0
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
 
-	for time.Now().Before(timeOut) {
-		fmt.Println("Скрипт продолжил работу в:", time.Now().Local().Format("15:04:05"))
-		if err := Trade(ctx, bc, profits, cpProfits, cfg); err != nil {
-			errCounter++
-			log.Println(err)
+// shutdownTrading завершает торговлю согласно ShutdownConfig.Policy:
+// оставляет позицию, отменяет ордера или закрывает позицию, сохраняет
+// состояние и выводит итоги сессии.
+func shutdownTrading(bc *BinanceClient, state *TradeState, cfg *Config, rm *RiskManager, summary *TradingSummary) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+
+	fmt.Println("Завершение работы, политика:", cfg.Shutdown.Policy)
+
+	var errs []error
+	if cfg.Shutdown.Policy == ShutdownCancelOrders || cfg.Shutdown.Policy == ShutdownFlatten {
+		// стоп-лоссы защищают позицию, которая остается открытой
+		keepStops := cfg.Shutdown.Policy != ShutdownFlatten
+		if _, err := binanceCheckAndCloseOrders(ctx, bc, keepStops, cfg); err != nil {
+			errs = append(errs, fmt.Errorf("cancel orders: %w", err))
 		}
-		if errCounter == 5 {
-			time.Sleep(4 * time.Minute)
-			errCounter = 0
-			continue
+	}
+
+	pos, posErr := binanceOpenedPositions(ctx, bc, cfg.Symbol)
+	if posErr != nil {
+		errs = append(errs, posErr)
+	} else {
+		if cfg.Shutdown.Policy == ShutdownFlatten {
+			if err := binanceCloseLegs(ctx, bc, pos, state, cfg); err != nil {
+				errs = append(errs, fmt.Errorf("close position: %w", err))
+			}
+		}
+		if _, err := rm.Update(pos, time.Now(), tradeResult(ctx, bc, pos, cfg)); err != nil {
+			errs = append(errs, fmt.Errorf("update risk state: %w", err))
 		}
-		time.Sleep(1 * time.Minute)
 	}
-}
 
-func gracefulShutdown(doneChan chan int, sigChan chan os.Signal) {
-	defer close(doneChan)
-	<-sigChan
+	if err := state.Save(); err != nil {
+		errs = append(errs, fmt.Errorf("save trade state: %w", err))
+	}
+
+	fmt.Printf("Время работы: %s, шагов: %d, ошибок: %d, пропущено свечей: %d\n",
+		time.Since(summary.Started).Round(time.Second), summary.Ticks, summary.Errors, summary.MissedTicks)
+	if posErr == nil {
+		if cfg.Shutdown.Policy != ShutdownFlatten {
+			for _, leg := range pos.Legs {
+				fmt.Printf("Оставлена позиция: %s %f по %f\n", leg.Side, leg.Amount, leg.EntryPrice)
+			}
+		}
+		fmt.Printf("Баланс: %.2f, изменение за сессию: %.2f\n", pos.Balance, pos.Balance-summary.StartBalance)
+	}
+
+	if income, err := binanceIncome(ctx, bc, cfg.Symbol, summary.Started); err != nil {
+		errs = append(errs, fmt.Errorf("income: %w", err))
+	} else {
+		printIncomeSummary(income)
+	}
+
+	return errors.Join(errs...)
 }
 
-// Trade основная торговая стратегия.
-func Trade(ctx context.Context, bc *futures.Client, srcProfitsArr, cpProfitArr [][]int, cfg *Config) error {
+// Trade основная торговая стратегия. Сигнал на вход оценивается
+// только при evaluateEntry, позиция управляется на каждом шаге.
+// В режиме хеджирования LONG и SHORT сопровождаются независимо.
+func Trade(ctx context.Context, bc *BinanceClient, state *TradeState, cfg *Config, rm *RiskManager, evaluateEntry bool) error {
 	pos, err := binanceOpenedPositions(ctx, bc, cfg.Symbol)
 	if err != nil {
 		return err
 	}
-	openPosition := pos.Position
+	state.Sync(pos, cfg.StopPercent)
+	if err = checkLiquidation(ctx, bc, pos, state, cfg); err != nil {
+		return err
+	}
+
+	halted, err := rm.Update(pos, time.Now(), tradeResult(ctx, bc, pos, cfg))
+	if err != nil {
+		return err
+	}
+	if halted {
+		fmt.Println("Торговля остановлена риск-менеджером:", rm.State().HaltReason)
+		if rm.ShouldFlatten() {
+			return binanceCloseLegs(ctx, bc, pos, state, cfg)
+		}
+	}
 
 	// если нет позиций
-	if openPosition == "" {
+	if len(pos.Legs) == 0 {
 		fmt.Println("Нет открытых позиций!")
 		// закрыть все stop-loss ордера
-		_, err = binanceCheckAndCloseOrders(ctx, bc, cfg.Symbol)
+		_, err = binanceCheckAndCloseOrders(ctx, bc, false, cfg)
 		if err != nil {
 			return err
 		}
+	}
 
-		sig, err := checkSignalToBuy(ctx, bc, 100, cfg)
-		if err != nil {
+	var funding *FundingInfo
+	if cfg.Funding.enabled() && (len(pos.Legs) > 0 || evaluateEntry) {
+		if funding, err = binanceFundingInfo(ctx, bc, cfg.Symbol); err != nil {
 			return err
 		}
+	}
 
-		copy(cpProfitArr, srcProfitsArr)
-
-		if sig == LONG {
-			fmt.Printf("Открыта новая позиция: %s\n", LONG)
-			err = binanceOpenPosition(ctx, bc, LONG, cfg.MaxPositionAmount, cfg)
-			if err != nil {
-				return err
-			}
-		} else if sig == SHORT {
-			fmt.Printf("Открыта новая позиция: %s\n", SHORT)
-			err = binanceOpenPosition(ctx, bc, SHORT, cfg.MaxPositionAmount, cfg)
-			if err != nil {
+	for _, leg := range pos.Legs {
+		if state.Leg(leg.Side).Manual {
+			fmt.Printf("Позиция %s - %f открыта вручную и не сопровождается\n", leg.Side, leg.Amount)
+			continue
+		}
+		if funding != nil && cfg.Funding.requiresExit(funding, leg.Side, time.Now()) {
+			fmt.Printf("Закрытие позиции %s перед расчетом финансирования: ставка %.4f%%\n", leg.Side, funding.PaidRate(leg.Side)*100)
+			clientID := clientOrderID(cfg, state.PositionID(leg), ActionFunding)
+			if err = binanceClosePosition(ctx, bc, leg.Side, leg.Amount, pos.Hedge, clientID, cfg.Execution.Stop, cfg); err != nil {
 				return err
 			}
+			continue
 		}
+		if err = manageLeg(ctx, bc, leg, state, pos.Hedge, cfg); err != nil {
+			return err
+		}
+	}
 
-	} else {
-		entryPrice := pos.EntryPrice
-		currentPrice := binanceCryptoPairPrice(ctx, bc, cfg.Symbol)
-		quantity := pos.Amount
-
-		fmt.Printf("Найдена открытая позиция: %s - %f\n", openPosition, quantity)
-
-		if openPosition == string(LONG) {
-			stopPrice := entryPrice * (1 - cfg.StopPercent)
-			if currentPrice < stopPrice {
-				// stop-loss
-				err = binanceClosePosition(ctx, bc, LONG, math.Abs(quantity), cfg)
-				if err != nil {
-					return err
-				}
-				copy(cpProfitArr, srcProfitsArr)
-			} else {
-				tempArr := make([][]int, len(cpProfitArr))
-				copy(tempArr, cpProfitArr)
-				for i := range tempArr {
-					delta := tempArr[i][0]
-					contracts := tempArr[i][1]
-					if currentPrice > entryPrice+float64(delta) {
-						// забрать профит
-						q := math.Abs(cfg.MaxPositionAmount * (float64(contracts) / 10))
-						err = binanceClosePosition(ctx, bc, LONG, q, cfg)
-						if err != nil {
-							return err
-						}
-						cpProfitArr = cpProfitArr[1:]
-					}
-				}
-			}
+	// в одностороннем режиме новая позиция открывается только без текущей
+	if !evaluateEntry || (!pos.Hedge && len(pos.Legs) > 0) {
+		return nil
+	}
+
+	sig, err := checkSignalToBuy(ctx, bc, 100, cfg)
+	if err != nil {
+		return err
+	}
+	if sig == "" || pos.Leg(sig) != nil {
+		return nil
+	}
+
+	if funding != nil && cfg.Funding.blocksEntry(funding, sig, time.Now()) {
+		fmt.Printf("Вход в позицию %s пропущен: ставка финансирования %.4f%% в %s\n",
+			sig, funding.PaidRate(sig)*100, funding.NextFundingTime.Local().Format("15:04"))
+		return nil
+	}
+
+	if err = cfg.Liquidation.checkStopBeforeLiquidation(pos, cfg.StopPercent); err != nil {
+		fmt.Printf("Вход в позицию %s заблокирован: %v\n", sig, err)
+		return nil
+	}
+
+	if err = cfg.SymbolSettings(cfg.Symbol).Check(pos); err != nil {
+		fmt.Printf("Вход в позицию %s заблокирован: %v\n", sig, err)
+		alert(fmt.Sprintf("Настройки %s на бирже не совпадают с конфигурацией: %v", cfg.Symbol, err))
+		return nil
+	}
+
+	quantity := cfg.MaxPositionAmount
+	price, err := binanceCryptoPairPrice(ctx, bc, cfg.Symbol)
+	if err != nil {
+		return err
+	}
+	if cfg.Liquidity.enabled() {
+		book, err := binanceOrderBook(ctx, bc, cfg.Symbol, cfg.Liquidity.DepthLimit)
+		if err != nil {
+			return err
+		}
+		est := cfg.Liquidity.estimateEntry(book, sig, quantity)
+		fmt.Printf("Оценка входа %s: средняя цена %f, проскальзывание %.3f%%, спред %.3f%%\n",
+			sig, est.AvgPrice, est.Slippage*100, est.Spread*100)
+		if est.Quantity <= 0 {
+			fmt.Printf("Вход в позицию %s пропущен: %s\n", sig, est.Reason)
+			return nil
 		}
+		if est.Quantity < quantity {
+			fmt.Printf("Объем входа %s уменьшен до %f: %s\n", sig, est.Quantity, est.Reason)
+		}
+		quantity, price = est.Quantity, est.AvgPrice
+	}
 
-		if openPosition == string(SHORT) {
-			stopPrice := entryPrice * (1 + cfg.StopPercent)
-			if currentPrice > stopPrice {
-				// stop-loss
-				err = binanceClosePosition(ctx, bc, SHORT, math.Abs(quantity), cfg)
-				if err != nil {
-					return err
-				}
-				copy(cpProfitArr, srcProfitsArr)
-			} else {
-				tempArr := make([][]int, len(cpProfitArr))
-				copy(tempArr, cpProfitArr)
-				for i := range tempArr {
-					delta := tempArr[i][0]
-					contracts := tempArr[i][1]
-					if currentPrice < entryPrice-float64(delta) {
-						// забрать профит
-						q := math.Abs(cfg.MaxPositionAmount * (float64(contracts) / 10))
-						err = binanceClosePosition(ctx, bc, SHORT, q, cfg)
-						if err != nil {
-							return err
-						}
-						cpProfitArr = cpProfitArr[1:]
-					}
-				}
-			}
+	if err = rm.CheckEntry(pos, quantity, price); err != nil {
+		fmt.Printf("Вход в позицию %s заблокирован: %v\n", sig, err)
+		return nil
+	}
+
+	fmt.Printf("Открыта новая позиция: %s\n", sig)
+	positionID := newPositionID(sig, time.Now())
+	state.Enter(sig, positionID)
+	return binanceOpenPosition(ctx, bc, sig, quantity, pos.Hedge, positionID, cfg)
+}
+
+// tradeResult результат сделки по стороне позиции для риск-менеджера:
+// по сделкам счета с открытия стороны до текущего момента.
+func tradeResult(ctx context.Context, bc *BinanceClient, pos *OpenedPosition, cfg *Config) TradeResultFunc {
+	return func(side TradingPosition, from time.Time) (float64, error) {
+		return binanceTradeResult(ctx, bc, cfg.Symbol, side, pos.Hedge, from, time.Now())
+	}
+}
+
+// manageLeg закрывает сторону позиции по стоп-лоссу или частично
+// фиксирует прибыль на уровнях ls.Ladder.
+func manageLeg(ctx context.Context, bc *BinanceClient, leg PositionLeg, state *TradeState, hedge bool, cfg *Config) error {
+	ls := state.Leg(leg.Side)
+	currentPrice, err := binanceCryptoPairPrice(ctx, bc, cfg.Symbol)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Найдена открытая позиция: %s - %f, ликвидация по %f\n", leg.Side, leg.Amount, leg.LiquidationPrice)
+
+	if err = syncStopOrder(ctx, bc, leg, ls, hedge, cfg); err != nil {
+		fmt.Println("Не удалось выставить стоп-лосс на бирже:", err)
+	}
+
+	// стоп-лосс на бирже может не сработать при отклонении ордера,
+	// поэтому уровень проверяется и здесь
+	if (leg.Side == LONG && currentPrice < ls.StopPrice) || (leg.Side == SHORT && currentPrice > ls.StopPrice) {
+		// stop-loss
+		clientID := clientOrderID(cfg, ls.PositionID, ActionStop)
+		return binanceClosePosition(ctx, bc, leg.Side, leg.Amount, hedge, clientID, cfg.Execution.Stop, cfg)
+	}
+
+	// следующий уровень фиксации прибыли - только после завершения ордера предыдущего
+	if ls.PendingTakeProfit > 0 {
+		if err = checkPendingTakeProfit(ctx, bc, leg, state, cfg); err != nil {
+			return err
+		}
+	}
+	for len(ls.Ladder) > 0 && ls.PendingTakeProfit == 0 {
+		delta := float64(ls.Ladder[0][0])
+		contracts := ls.Ladder[0][1]
+		if (leg.Side == LONG && currentPrice <= ls.EntryPrice+delta) || (leg.Side == SHORT && currentPrice >= ls.EntryPrice-delta) {
+			break
+		}
+
+		// забрать профит
+		// доля от фактического объема входа: он мог быть уменьшен по ликвидности
+		q := math.Abs(ls.EntryAmount * (float64(contracts) / 10))
+		if q == 0 {
+			ls.Ladder = ls.Ladder[1:]
+			continue
+		}
+		level := state.TakeProfitLevel(ls)
+		clientID := clientOrderID(cfg, ls.PositionID, actionTakeProfit(level))
+		if err = binanceClosePosition(ctx, bc, leg.Side, q, hedge, clientID, cfg.Execution.TakeProfit, cfg); err != nil {
+			return err
+		}
+		ls.PendingTakeProfit = level
+	}
+
+	return nil
+}
+
+// checkPendingTakeProfit проверяет на бирже ордер фиксации прибыли, о
+// завершении которого не сообщил поток данных пользователя.
+func checkPendingTakeProfit(ctx context.Context, bc *BinanceClient, leg PositionLeg, state *TradeState, cfg *Config) error {
+	ls := state.Leg(leg.Side)
+	clientID := clientOrderID(cfg, ls.PositionID, actionTakeProfit(ls.PendingTakeProfit))
+	o, err := binanceOrderByClientID(ctx, bc, cfg.Symbol, clientID)
+	if err != nil {
+		return err
+	}
+	if o == nil {
+		// биржа не приняла ордер
+		state.settleTakeProfit(leg.Side, ls, ls.PendingTakeProfit, futures.OrderStatusTypeRejected, 0)
+		return nil
+	}
+	state.settleTakeProfit(leg.Side, ls, ls.PendingTakeProfit, o.Status, executedQuantity(o))
+	return nil
+}
+
+// syncStopOrder выставляет на бирже стоп-лосс стороны позиции, если он
+// еще не выставлен, и перевыставляет его при изменении уровня.
+func syncStopOrder(ctx context.Context, bc *BinanceClient, leg PositionLeg, ls *LegState, hedge bool, cfg *Config) error {
+	if ls.StopOrderID != 0 && ls.StopMoved {
+		if err := binanceCancelOrder(ctx, bc, cfg.Symbol, ls.StopOrderID); err != nil {
+			return err
 		}
+		ls.StopOrderID = 0
+	}
+	ls.StopMoved = false
+	if ls.StopOrderID != 0 {
+		return nil
 	}
 
+	id, err := binancePlaceStopOrder(ctx, bc, leg.Side, ls.StopPrice, hedge, ls.PositionID, cfg)
+	if err != nil {
+		return err
+	}
+	ls.StopOrderID = id
+	fmt.Printf("Стоп-лосс %s выставлен на бирже по %.2f\n", leg.Side, ls.StopPrice)
 	return nil
 }
 
@@ -220,21 +487,21 @@ func writeKLinesToCsv(klines []*futures.Kline, filepath string) error {
 }
 
 // PrepareDataFrame подготавливает датафрейм для дальнейшей работы.
-func PrepareDataFrame(df *dataframe.DataFrame) *dataframe.DataFrame {
+func PrepareDataFrame(df *dataframe.DataFrame, p StrategyParams) *dataframe.DataFrame {
 	// рассчитать индикатор ATR
-	df = IndicateATR(dataframe.NewDataFrame(df.Series...), 14)
+	df = IndicateATR(dataframe.NewDataFrame(df.Series...), p.ATRPeriod)
 
 	// рассчитать и добавить индикатор наклона в датафрейм
 	closeVals := df.Series[df.MustNameToColumn("close")].(*dataframe.SeriesFloat64).Values
-	slope := IndicateSlope(closeVals, 5)
+	slope := IndicateSlope(closeVals, p.SlopeWindow)
 	_ = df.AddSeries(dataframe.NewSeriesFloat64("slope", nil, slope), nil)
 
 	// рассчитать максимальный канал
-	maxChannel := rollingMax(df, "high", 10)
+	maxChannel := rollingMax(df, "high", p.ChannelWindow)
 	_ = df.AddSeries(dataframe.NewSeriesFloat64("chan_max", nil, maxChannel), nil)
 
 	// рассчитать минимальный канал
-	minChanel := rollingMin(df, "low", 10)
+	minChanel := rollingMin(df, "low", p.ChannelWindow)
 	_ = df.AddSeries(dataframe.NewSeriesFloat64("chan_min", nil, minChanel), nil)
 
 	// рассчитать позицию в канале
@@ -388,7 +655,6 @@ func IndicateATR(df *dataframe.DataFrame, period int) *dataframe.DataFrame {
 }
 
 func isLocalMinimumIdx(df *dataframe.DataFrame, idx int) int {
-	df = df.Copy()
 	localMin := 0
 
 	closeSer := df.Series[df.MustNameToColumn("close")]
@@ -403,7 +669,6 @@ func isLocalMinimumIdx(df *dataframe.DataFrame, idx int) int {
 }
 
 func isLocalMaximumIdx(df *dataframe.DataFrame, idx int) int {
-	df = df.Copy()
 	localMax := 0
 
 	closeSer := df.Series[df.MustNameToColumn("close")]
@@ -434,82 +699,169 @@ func MinMaxChannel(df *dataframe.DataFrame, n int) (min float64, max float64) {
 	return
 }
 
+// SignalDecision результат оценки сигнала на одной свече.
+type SignalDecision struct {
+	Index int
+	// CandleTime время начала свечи Index.
+	CandleTime time.Time
+	LocalMin   bool
+	LocalMax   bool
+	PosInChan  float64
+	Slope      float64
+	Signal     TradingPosition
+	Reason     string
+	// Confirm проверка на старшем таймфрейме, nil - не проверялся.
+	Confirm *TimeframeCheck
+	// VetoedBy таймфрейм, отклонивший сигнал.
+	VetoedBy string
+	// Conditions проверенные условия входа в порядке проверки.
+	Conditions []SignalCondition
+}
+
+// SignalCondition условие входа: значение показателя, порог и результат.
+type SignalCondition struct {
+	Name      string    `json:"name"`
+	Value     jsonFloat `json:"value"`
+	Threshold jsonFloat `json:"threshold"`
+	Passed    bool      `json:"passed"`
+}
+
+// addCondition добавляет проверенное условие и возвращает его результат.
+func (d *SignalDecision) addCondition(name string, value, threshold float64, passed bool) bool {
+	d.Conditions = append(d.Conditions, SignalCondition{
+		Name:      name,
+		Value:     jsonFloat(value),
+		Threshold: jsonFloat(threshold),
+		Passed:    passed,
+	})
+	return passed
+}
+
 // checkSignalToBuy проверяет и находит места выгодные для покупки.
-func checkSignalToBuy(ctx context.Context, bc *futures.Client, limit int, cfg *Config) (TradingPosition, error) {
-	var sig TradingPosition
+func checkSignalToBuy(ctx context.Context, bc *BinanceClient, limit int, cfg *Config) (TradingPosition, error) {
+	decision, err := checkSignal(ctx, bc, limit, cfg)
+	if err != nil {
+		return "", err
+	}
+	return decision.Signal, nil
+}
+
+// checkSignal оценивает сигнал на последней закрытой свече и записывает
+// обоснование в журнал сигналов.
+func checkSignal(ctx context.Context, bc *BinanceClient, limit int, cfg *Config) (SignalDecision, error) {
+	d, err := evaluateLatestSignal(ctx, bc, limit, cfg)
+	if cfg.SignalLogFile != "" {
+		rec := newSignalRecord(d, cfg, time.Now())
+		if err != nil {
+			// оценка прервана: сигнала нет, показатели могли не посчитаться
+			rec.Signal, rec.Reason, rec.Error = "", "оценка прервана ошибкой", err.Error()
+			if d.CandleTime.IsZero() {
+				rec.PosInChan, rec.Slope = jsonFloat(math.NaN()), jsonFloat(math.NaN())
+			}
+		}
+		if logErr := appendSignalRecord(cfg.SignalLogFile, rec); logErr != nil {
+			log.Println("signal log:", logErr)
+		}
+	}
+	return d, err
+}
 
+// evaluateLatestSignal получает последние свечи и оценивает сигнал
+// на последней закрытой свече.
+func evaluateLatestSignal(ctx context.Context, bc *BinanceClient, limit int, cfg *Config) (SignalDecision, error) {
 	// Текущая свеча - (limit-1), которая ещё не закрыта,
 	// (limit-2) - последняя закрытая свеча.
 	// Нам необходима свеча (limit-3), чтобы проверить, верх это или низ.
 	lastCandle := limit - 2
 	if lastCandle <= 0 {
-		return sig, fmt.Errorf("limit must be greater than 2")
+		return SignalDecision{}, fmt.Errorf("limit must be greater than 2")
 	}
 
-	ohlc, err := binanceKlinesFuturesDataframe(ctx, bc, limit, cfg)
+	ohlc, err := binanceKlinesFuturesDataframe(ctx, bc, limit, cfg.Interval, cfg)
 	if err != nil {
-		return sig, err
+		return SignalDecision{}, err
 	}
 
-	df := PrepareDataFrame(ohlc)
+	p := cfg.StrategyParams()
+	df := PrepareDataFrame(ohlc, p)
+
+	d, err := evaluateSignal(df, lastCandle-1, p)
+	if err != nil || d.Signal == "" {
+		return d, err
+	}
+	if cfg.Confirm.Interval != "" {
+		if d, err = confirmSignal(ctx, bc, d, df, lastCandle, cfg); err != nil || d.Signal == "" {
+			return d, err
+		}
+	}
+
+	// сохранить график точки входа для последующего разбора
+	closes := df.Series[df.MustNameToColumn("close")].(*dataframe.SeriesFloat64).Values
+	entryTime := time.UnixMilli(df.Series[df.MustNameToColumn("date")].Value(lastCandle).(int64))
+	path, err := saveChart(df, ChartOptions{
+		Symbol:   cfg.Symbol,
+		Interval: cfg.Interval,
+		Dir:      cfg.ChartsDir,
+		Format:   cfg.ChartFormat,
+		Markers:  []ChartMarker{{Time: entryTime, Price: closes[lastCandle], Kind: "entry"}},
+	})
+	if err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("График сохранен в", path)
+	}
+
+	return d, nil
+}
+
+// evaluateSignal проверяет, является ли свеча idx подготовленного
+// датафрейма точкой входа. Для проверки нужна следующая закрытая свеча.
+func evaluateSignal(df *dataframe.DataFrame, idx int, p StrategyParams) (SignalDecision, error) {
 	posInChanIdx := df.MustNameToColumn("pos_in_chan")
 	slopeIdx := df.MustNameToColumn("slope")
 
-	saveChartAsSVG(df, 1, float64(limit))
+	d := SignalDecision{
+		Index:      idx,
+		CandleTime: time.UnixMilli(df.Series[df.MustNameToColumn("date")].Value(idx).(int64)),
+		LocalMin:   isLocalMinimumIdx(df, idx) > 0,
+		LocalMax:   isLocalMaximumIdx(df, idx) > 0,
+		PosInChan:  df.Series[posInChanIdx].Value(idx).(float64),
+		Reason:     "локальный экстремум не найден",
+	}
+
+	slope, valid := df.Series[slopeIdx].Value(idx).(float64)
+	if !valid {
+		d.Slope = math.NaN()
+		return d, fmt.Errorf("get slope error: %v", df)
+	}
+	d.Slope = slope
 
-	if isLocalMinimumIdx(df, lastCandle-1) > 0 {
+	if d.LocalMin {
 		// найден низ, значит открыть LONG позицию
-		if df.Series[posInChanIdx].Value(lastCandle-1).(float64) < 0.5 {
+		d.Reason = fmt.Sprintf("найден низ, позиция в канале не ниже %g", p.PosInChanThreshold)
+		if d.addCondition("pos_in_chan_below", d.PosInChan, p.PosInChanThreshold, d.PosInChan < p.PosInChanThreshold) {
 			// закрыть по верхней границе канала
-			slope, valid := df.Series[slopeIdx].Value(lastCandle - 1).(float64)
-			if !valid {
-				return sig, fmt.Errorf("get slope error: %v", df)
-			}
-			if slope < -20 {
+			d.Reason = fmt.Sprintf("найден низ, наклон не меньше %g", -p.SlopeThreshold)
+			if d.addCondition("slope_below", slope, -p.SlopeThreshold, slope < -p.SlopeThreshold) {
 				// найдена хорошая точка входа для LONG
-				sig = LONG
+				d.Signal = LONG
+				d.Reason = fmt.Sprintf("найден низ в нижней части канала с наклоном меньше %g", -p.SlopeThreshold)
 			}
 		}
 	}
 
-	if isLocalMaximumIdx(df, lastCandle-1) > 0 {
+	if d.LocalMax {
 		// найден верх, значит открыть SHORT позицию
-		if df.Series[posInChanIdx].Value(lastCandle-1).(float64) > 0.5 {
+		d.Reason = fmt.Sprintf("найден верх, позиция в канале не выше %g", p.PosInChanThreshold)
+		if d.addCondition("pos_in_chan_above", d.PosInChan, p.PosInChanThreshold, d.PosInChan > p.PosInChanThreshold) {
 			// закрыть по верхней позиции канала
-			if df.Series[slopeIdx].Value(lastCandle-1).(float64) > 20 {
-				sig = SHORT
+			d.Reason = fmt.Sprintf("найден верх, наклон не больше %g", p.SlopeThreshold)
+			if d.addCondition("slope_above", slope, p.SlopeThreshold, slope > p.SlopeThreshold) {
+				d.Signal = SHORT
+				d.Reason = fmt.Sprintf("найден верх в верхней части канала с наклоном больше %g", p.SlopeThreshold)
 			}
 		}
 	}
 
-	return sig, nil
-}
-
-// saveChartAsSVG сохраняет график каналов как векторное изображение,
-// принимая минимальный и максимальный номер свечи.
-func saveChartAsSVG(df *dataframe.DataFrame, min, max float64) {
-	chanMax := df.Series[df.MustNameToColumn("chan_max")].(*dataframe.SeriesFloat64)
-	closes := df.Series[df.MustNameToColumn("close")].(*dataframe.SeriesFloat64)
-	chanMin := df.Series[df.MustNameToColumn("chan_min")].(*dataframe.SeriesFloat64)
-	xValues := chart.Seq{Sequence: chart.NewLinearSequence().WithStart(min).WithEnd(max)}.Values()
-	graph := chart.Chart{
-		Series: []chart.Series{
-			chart.ContinuousSeries{
-				XValues: xValues,
-				YValues: chanMax.Values,
-			},
-			chart.ContinuousSeries{
-				XValues: xValues,
-				YValues: closes.Values,
-			},
-			chart.ContinuousSeries{
-				XValues: xValues,
-				YValues: chanMin.Values,
-			},
-		},
-	}
-
-	f, _ := os.Create("./images/output.svg")
-	defer f.Close()
-	_ = graph.Render(chart.SVG, f)
+	return d, nil
 }
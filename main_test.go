@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"github.com/rocketlaunchr/dataframe-go"
+	"testing"
+	"time"
+)
+
+// signalFrame свечи 15m от старых к новым: падение до локального минимума
+// на свече n-3, закрытая свеча n-2 выше него и текущая свеча n-1.
+func signalFrame(n int) *dataframe.DataFrame {
+	start := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	dates := make([]int64, n)
+	opens, highs, lows, closes, volumes := make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := range dates {
+		dates[i] = start.Add(time.Duration(i) * 15 * time.Minute).UnixMilli()
+		closes[i] = 200 - 3*float64(i)
+	}
+	closes[n-2] = closes[n-3] + 5
+	closes[n-1] = closes[n-2] + 1
+	for i, c := range closes {
+		opens[i], highs[i], lows[i], volumes[i] = c, c+1, c-1, 1
+	}
+	return dataframe.NewDataFrame(
+		dataframe.NewSeriesInt64("date", nil, dates),
+		dataframe.NewSeriesFloat64("open", nil, opens),
+		dataframe.NewSeriesFloat64("high", nil, highs),
+		dataframe.NewSeriesFloat64("low", nil, lows),
+		dataframe.NewSeriesFloat64("close", nil, closes),
+		dataframe.NewSeriesFloat64("volume", nil, volumes),
+	)
+}
+
+func TestPrepareDataFrameOrder(t *testing.T) {
+	const limit = 30
+	p := defaultStrategyParams()
+	// как в evaluateLatestSignal: проверяется свеча limit-3 по закрытой limit-2
+	idx := limit - 3
+
+	ohlc := signalFrame(limit)
+	want := time.UnixMilli(ohlc.Series[0].Value(idx).(int64))
+	d, err := evaluateSignal(PrepareDataFrame(ohlc, p), idx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Signal != LONG || !d.CandleTime.Equal(want) {
+		t.Errorf("oldest first: signal %q at %s (%s), want %q at %s", d.Signal, d.CandleTime, d.Reason, LONG, want)
+	}
+	if d.Slope >= -p.SlopeThreshold {
+		t.Errorf("oldest first: slope %f, want below %f", d.Slope, -p.SlopeThreshold)
+	}
+
+	// от новых к старым индекс limit-3 указывает на третью с начала свечу,
+	// а окна наклона и канала захватывают более поздние свечи
+	ohlc = signalFrame(limit)
+	ohlc.Sort(context.Background(), []dataframe.SortKey{{Key: "date", Desc: true}})
+	d, err = evaluateSignal(PrepareDataFrame(ohlc, p), idx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Signal != "" || d.CandleTime.Equal(want) || d.Slope <= 0 {
+		t.Errorf("newest first: signal %q at %s, slope %f", d.Signal, d.CandleTime, d.Slope)
+	}
+}
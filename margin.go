@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+	"strings"
+)
+
+// codeNoNeedToChangeMarginType биржа отвечает этим кодом, если тип маржи
+// уже установлен.
+const codeNoNeedToChangeMarginType = -4046
+
+// SymbolConfig настройки торговли валютной парой на бирже.
+type SymbolConfig struct {
+	// Leverage плечо, 0 - не управлять плечом.
+	Leverage int `mapstructure:"leverage"`
+	// MarginType тип маржи: ISOLATED или CROSSED, пустой - не управлять.
+	MarginType string `mapstructure:"marginType"`
+}
+
+// SymbolSettings возвращает настройки валютной пары symbol. Ключи
+// конфигурации viper приводит к нижнему регистру, поэтому поиск
+// не зависит от регистра.
+func (c *Config) SymbolSettings(symbol string) SymbolConfig {
+	for name, s := range c.Symbols {
+		if strings.EqualFold(name, symbol) {
+			return s
+		}
+	}
+	return SymbolConfig{}
+}
+
+// Validate проверяет настройки валютной пары.
+func (s SymbolConfig) Validate() error {
+	var errs []error
+	if s.Leverage < 0 || s.Leverage > 125 {
+		errs = append(errs, fmt.Errorf("leverage must be between 0 and 125, got %d", s.Leverage))
+	}
+	if s.MarginType != "" && s.MarginType != string(futures.MarginTypeIsolated) && s.MarginType != string(futures.MarginTypeCrossed) {
+		errs = append(errs, fmt.Errorf("marginType must be ISOLATED or CROSSED, got %q", s.MarginType))
+	}
+	return errors.Join(errs...)
+}
+
+// Check сравнивает настройки позиции на бирже с конфигурацией.
+func (s SymbolConfig) Check(pos *OpenedPosition) error {
+	if s.Leverage > 0 && int(pos.Leverage) != s.Leverage {
+		return fmt.Errorf("leverage on exchange is %.0f, config requires %d", pos.Leverage, s.Leverage)
+	}
+	if s.MarginType != "" && marginTypeOf(pos) != s.MarginType {
+		return fmt.Errorf("margin type on exchange is %s, config requires %s", marginTypeOf(pos), s.MarginType)
+	}
+	return nil
+}
+
+func marginTypeOf(pos *OpenedPosition) string {
+	if pos.Isolated {
+		return string(futures.MarginTypeIsolated)
+	}
+	return string(futures.MarginTypeCrossed)
+}
+
+// binanceApplySymbolSettings устанавливает на бирже плечо и тип маржи
+// валютной пары. Тип маржи нельзя сменить при открытой позиции или ордерах,
+// в этом случае возвращается ошибка биржи.
+func binanceApplySymbolSettings(ctx context.Context, bc *BinanceClient, symbol string, s SymbolConfig) error {
+	if s.MarginType != "" {
+		err := bc.call(ctx, true, func() error {
+			return bc.NewChangeMarginTypeService().
+				Symbol(symbol).
+				MarginType(futures.MarginType(s.MarginType)).
+				Do(ctx, bc.recvWindow())
+		})
+		var apiErr *common.APIError
+		if err != nil && !(errors.As(err, &apiErr) && apiErr.Code == codeNoNeedToChangeMarginType) {
+			return fmt.Errorf("set margin type %s: %w", s.MarginType, err)
+		}
+	}
+
+	if s.Leverage > 0 {
+		err := bc.call(ctx, true, func() error {
+			_, err := bc.NewChangeLeverageService().
+				Symbol(symbol).
+				Leverage(s.Leverage).
+				Do(ctx, bc.recvWindow())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("set leverage %d: %w", s.Leverage, err)
+		}
+	}
+
+	return nil
+}
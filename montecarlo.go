@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/stat"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MonteCarloOptions параметры анализа устойчивости методом Монте-Карло.
+type MonteCarloOptions struct {
+	// Runs кол-во симуляций.
+	Runs int
+	Seed int64
+	// Method способ формирования последовательности сделок:
+	// shuffle - перестановка, bootstrap - выборка с возвращением.
+	Method string
+	// Slippage максимальное проскальзывание цены входа в долях от цены,
+	// для каждой сделки выбирается случайно от нуля до этого значения.
+	Slippage float64
+	// DropRate вероятность того, что сделка не будет исполнена.
+	DropRate float64
+	// Ruin доля начального баланса, потеря которой считается разорением.
+	Ruin float64
+	// Confidence уровни доверия для отчета.
+	Confidence []float64
+}
+
+// MonteCarloResult распределения показателей по всем симуляциям.
+type MonteCarloResult struct {
+	StartBalance float64
+	// FinalEquity итоговый капитал симуляций по возрастанию.
+	FinalEquity []float64
+	// MaxDrawdown максимальная просадка симуляций в долях от пика по возрастанию.
+	MaxDrawdown []float64
+	// RiskOfRuin доля симуляций, в которых капитал опускался до уровня разорения.
+	RiskOfRuin float64
+	Confidence []float64
+}
+
+// monteCarlo многократно переигрывает сделки прогона по истории
+// в случайном порядке, со случайным проскальзыванием на входе
+// и пропуском части сделок.
+func monteCarlo(trades []BacktestTrade, balance float64, opts MonteCarloOptions) (*MonteCarloResult, error) {
+	if len(trades) == 0 {
+		return nil, fmt.Errorf("no trades for monte carlo")
+	}
+	if opts.Runs < 1 {
+		return nil, fmt.Errorf("runs must be positive")
+	}
+	if opts.Method != "shuffle" && opts.Method != "bootstrap" {
+		return nil, fmt.Errorf("unknown method %q", opts.Method)
+	}
+
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	ruinLevel := balance * (1 - opts.Ruin)
+	res := &MonteCarloResult{
+		StartBalance: balance,
+		FinalEquity:  make([]float64, opts.Runs),
+		MaxDrawdown:  make([]float64, opts.Runs),
+		Confidence:   opts.Confidence,
+	}
+	ruined := 0
+
+	order := make([]int, len(trades))
+	for run := 0; run < opts.Runs; run++ {
+		for i := range order {
+			if opts.Method == "shuffle" {
+				order[i] = i
+			} else {
+				order[i] = rnd.Intn(len(trades))
+			}
+		}
+		if opts.Method == "shuffle" {
+			rnd.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		}
+
+		equity, peak, maxDD, isRuined := balance, balance, 0.0, false
+		for _, i := range order {
+			if rnd.Float64() < opts.DropRate {
+				continue
+			}
+			t := trades[i]
+			// проскальзывание всегда против позиции
+			slip := t.EntryPrice * opts.Slippage * rnd.Float64()
+			equity += t.PnL - slip*t.Quantity
+
+			peak = math.Max(peak, equity)
+			if peak > 0 {
+				maxDD = math.Max(maxDD, (peak-equity)/peak)
+			}
+			if equity <= ruinLevel {
+				isRuined = true
+			}
+		}
+
+		res.FinalEquity[run] = equity
+		res.MaxDrawdown[run] = maxDD
+		if isRuined {
+			ruined++
+		}
+	}
+
+	sort.Float64s(res.FinalEquity)
+	sort.Float64s(res.MaxDrawdown)
+	res.RiskOfRuin = float64(ruined) / float64(opts.Runs)
+
+	return res, nil
+}
+
+// printMonteCarloReport выводит худший итоговый капитал и просадку
+// на каждом уровне доверия.
+func printMonteCarloReport(res *MonteCarloResult) {
+	fmt.Printf("Симуляций: %d\n", len(res.FinalEquity))
+	fmt.Printf("Медиана итогового капитала: %.2f\n", stat.Quantile(0.5, stat.Empirical, res.FinalEquity, nil))
+	fmt.Printf("Медиана максимальной просадки: %.2f%%\n", stat.Quantile(0.5, stat.Empirical, res.MaxDrawdown, nil)*100)
+	for _, c := range res.Confidence {
+		fmt.Printf("С вероятностью %g%%: итоговый капитал не ниже %.2f, просадка не больше %.2f%%\n",
+			c*100,
+			stat.Quantile(1-c, stat.Empirical, res.FinalEquity, nil),
+			stat.Quantile(c, stat.Empirical, res.MaxDrawdown, nil)*100)
+	}
+	fmt.Printf("Риск разорения: %.2f%%\n", res.RiskOfRuin*100)
+}
+
+// parseConfidence разбирает список уровней доверия через запятую.
+func parseConfidence(value string) ([]float64, error) {
+	var levels []float64
+	for _, part := range strings.Split(value, ",") {
+		c, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || c <= 0 || c >= 1 {
+			return nil, fmt.Errorf("invalid confidence level %q", part)
+		}
+		levels = append(levels, c)
+	}
+	return levels, nil
+}
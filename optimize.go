@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/rocketlaunchr/dataframe-go"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// optimizableParams параметры стратегии, которые можно перебирать
+// при оптимизации.
+var optimizableParams = map[string]func(p *StrategyParams, v float64){
+	"channelWindow":      func(p *StrategyParams, v float64) { p.ChannelWindow = int(math.Round(v)) },
+	"slopeWindow":        func(p *StrategyParams, v float64) { p.SlopeWindow = int(math.Round(v)) },
+	"slopeThreshold":     func(p *StrategyParams, v float64) { p.SlopeThreshold = v },
+	"posInChanThreshold": func(p *StrategyParams, v float64) { p.PosInChanThreshold = v },
+	"atrPeriod":          func(p *StrategyParams, v float64) { p.ATRPeriod = int(math.Round(v)) },
+	"stopPercent":        func(p *StrategyParams, v float64) { p.StopPercent = v },
+	// ladderScale умножает отклонения всех уровней лестницы.
+	"ladderScale": func(p *StrategyParams, v float64) {
+		ladder := make([][]int, len(p.Ladder))
+		for i, level := range p.Ladder {
+			ladder[i] = []int{int(math.Round(float64(level[0]) * v)), level[1]}
+		}
+		p.Ladder = ladder
+	},
+}
+
+// optimizationObjectives целевые функции для ранжирования результатов.
+var optimizationObjectives = map[string]func(st BacktestStats) float64{
+	"net-profit":    func(st BacktestStats) float64 { return st.NetProfit },
+	"profit-factor": func(st BacktestStats) float64 { return st.ProfitFactor },
+	"sharpe":        func(st BacktestStats) float64 { return st.Sharpe },
+	"win-rate":      func(st BacktestStats) float64 { return st.WinRate },
+	// return-drawdown прибыль на единицу максимальной просадки.
+	"return-drawdown": func(st BacktestStats) float64 {
+		if st.MaxDrawdown == 0 {
+			return st.NetProfit
+		}
+		return st.NetProfit / st.MaxDrawdown
+	},
+}
+
+// ParamRange диапазон значений параметра стратегии.
+type ParamRange struct {
+	Name string
+	Min  float64
+	Max  float64
+	Step float64
+}
+
+// parseParamRange разбирает диапазон в формате name=min:max:step.
+func parseParamRange(value string) (ParamRange, error) {
+	name, bounds, ok := strings.Cut(value, "=")
+	if !ok {
+		return ParamRange{}, fmt.Errorf("invalid range %q, expected name=min:max:step", value)
+	}
+	if _, ok = optimizableParams[name]; !ok {
+		return ParamRange{}, fmt.Errorf("unknown parameter %q", name)
+	}
+
+	parts := strings.Split(bounds, ":")
+	if len(parts) != 3 {
+		return ParamRange{}, fmt.Errorf("invalid range %q, expected name=min:max:step", value)
+	}
+	vals := make([]float64, 3)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return ParamRange{}, fmt.Errorf("invalid range %q: %w", value, err)
+		}
+		vals[i] = v
+	}
+
+	r := ParamRange{Name: name, Min: vals[0], Max: vals[1], Step: vals[2]}
+	if r.Step <= 0 || r.Max < r.Min {
+		return ParamRange{}, fmt.Errorf("invalid range %q: need min <= max and positive step", value)
+	}
+
+	return r, nil
+}
+
+// Values возвращает все значения диапазона.
+func (r ParamRange) Values() []float64 {
+	n := int(math.Floor((r.Max-r.Min)/r.Step+1e-9)) + 1
+	vals := make([]float64, n)
+	for i := range vals {
+		vals[i] = r.Min + float64(i)*r.Step
+	}
+	return vals
+}
+
+// paramRangesFlag флаг командной строки, который можно указать несколько раз.
+type paramRangesFlag []ParamRange
+
+func (f *paramRangesFlag) String() string {
+	names := make([]string, len(*f))
+	for i, r := range *f {
+		names[i] = fmt.Sprintf("%s=%g:%g:%g", r.Name, r.Min, r.Max, r.Step)
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *paramRangesFlag) Set(value string) error {
+	r, err := parseParamRange(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, r)
+	return nil
+}
+
+// OptimizeOptions параметры оптимизации.
+type OptimizeOptions struct {
+	// Backtest базовые параметры прогона, перебираемые параметры
+	// стратегии подставляются в Backtest.Params.
+	Backtest BacktestOptions
+	Ranges   []ParamRange
+	// Search способ перебора: grid или random.
+	Search string
+	// Samples кол-во случайных наборов для Search = random.
+	Samples   int
+	Seed      int64
+	Objective string
+	// MinTrades минимальное кол-во сделок, при котором результат ранжируется.
+	MinTrades int
+	Workers   int
+}
+
+// OptimizationResult результат прогона с одним набором параметров.
+type OptimizationResult struct {
+	// Values значения параметров в порядке OptimizeOptions.Ranges.
+	Values []float64
+	Params StrategyParams
+	Stats  BacktestStats
+	Score  float64
+}
+
+// optimize прогоняет стратегию по df с каждым набором параметров
+// параллельно в нескольких горутинах и возвращает результаты,
+// отсортированные по убыванию целевой функции.
+func optimize(df *dataframe.DataFrame, cfg *Config, opts OptimizeOptions) ([]OptimizationResult, error) {
+	objective, ok := optimizationObjectives[opts.Objective]
+	if !ok {
+		return nil, fmt.Errorf("unknown objective %q", opts.Objective)
+	}
+	if len(opts.Ranges) == 0 {
+		return nil, fmt.Errorf("at least one parameter range is required")
+	}
+
+	var combos [][]float64
+	switch opts.Search {
+	case "grid":
+		combos = gridCombinations(opts.Ranges)
+	case "random":
+		combos = randomCombinations(opts.Ranges, opts.Samples, opts.Seed)
+	default:
+		return nil, fmt.Errorf("unknown search %q", opts.Search)
+	}
+
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]OptimizationResult, len(combos))
+	errs := make([]error, len(combos))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				params := opts.Backtest.Params
+				for j, r := range opts.Ranges {
+					optimizableParams[r.Name](&params, combos[i][j])
+				}
+				if err := params.Validate(); err != nil {
+					errs[i] = fmt.Errorf("invalid parameters %v: %w", combos[i], err)
+					continue
+				}
+
+				btOpts := opts.Backtest
+				btOpts.Params = params
+				res, err := runBacktest(df, cfg, btOpts)
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+
+				st := res.Stats()
+				score := objective(st)
+				if st.Trades < opts.MinTrades || math.IsNaN(score) {
+					score = math.Inf(-1)
+				}
+				results[i] = OptimizationResult{Values: combos[i], Params: params, Stats: st, Score: score}
+			}
+		}()
+	}
+
+	for i := range combos {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	return results, nil
+}
+
+// gridCombinations перебирает все сочетания значений диапазонов.
+func gridCombinations(ranges []ParamRange) [][]float64 {
+	combos := [][]float64{{}}
+	for _, r := range ranges {
+		var next [][]float64
+		for _, combo := range combos {
+			for _, v := range r.Values() {
+				next = append(next, append(append([]float64(nil), combo...), v))
+			}
+		}
+		combos = next
+	}
+	return combos
+}
+
+// randomCombinations выбирает n случайных сочетаний значений диапазонов.
+func randomCombinations(ranges []ParamRange, n int, seed int64) [][]float64 {
+	rnd := rand.New(rand.NewSource(seed))
+	combos := make([][]float64, n)
+	for i := range combos {
+		combo := make([]float64, len(ranges))
+		for j, r := range ranges {
+			vals := r.Values()
+			combo[j] = vals[rnd.Intn(len(vals))]
+		}
+		combos[i] = combo
+	}
+	return combos
+}
+
+// writeOptimizationCsv записывает таблицу результатов оптимизации.
+func writeOptimizationCsv(results []OptimizationResult, ranges []ParamRange, filepath string) error {
+	csvFile, err := os.Create(filepath)
+	if err != nil {
+		return err
+	}
+	defer csvFile.Close()
+
+	writer := csv.NewWriter(csvFile)
+	defer writer.Flush()
+
+	header := []string{"rank", "score"}
+	for _, r := range ranges {
+		header = append(header, r.Name)
+	}
+	header = append(header, "trades", "win_rate", "net_profit", "profit_factor", "max_drawdown", "sharpe")
+	if err = writer.Write(header); err != nil {
+		return err
+	}
+
+	for i, res := range results {
+		record := []string{strconv.Itoa(i + 1), formatFloat(res.Score)}
+		for _, v := range res.Values {
+			record = append(record, formatFloat(v))
+		}
+		record = append(record,
+			strconv.Itoa(res.Stats.Trades),
+			formatFloat(res.Stats.WinRate),
+			formatFloat(res.Stats.NetProfit),
+			formatFloat(res.Stats.ProfitFactor),
+			formatFloat(res.Stats.MaxDrawdown),
+			formatFloat(res.Stats.Sharpe),
+		)
+		if err = writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
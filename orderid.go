@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Действия бота, из которых составляется ID ордера.
+const (
+	// ActionEntry вход в позицию.
+	ActionEntry = "open"
+	// ActionStop закрытие позиции ботом по стоп-лоссу.
+	ActionStop = "stop"
+	// ActionStopOrder стоп-лосс, выставленный на бирже.
+	ActionStopOrder = "sl"
+	// ActionFunding закрытие позиции перед расчетом финансирования.
+	ActionFunding = "fund"
+	// ActionLiquidation сокращение позиции из-за близости ликвидации.
+	ActionLiquidation = "liq"
+	// ActionClose принудительное закрытие позиции.
+	ActionClose = "close"
+)
+
+const (
+	// maxClientOrderIDLen максимальная длина ID ордера на бирже.
+	maxClientOrderIDLen = 36
+	// maxPositionIDLen максимальная длина ID позиции в ID ордера.
+	maxPositionIDLen = 8
+	// codeOrderNotExist биржа отвечает этим кодом, если ордер не найден.
+	codeOrderNotExist = -2013
+)
+
+// botIDPattern допустимый ID бота: он входит в ID каждого ордера.
+var botIDPattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,8}$`)
+
+// actionTakeProfit действие фиксации прибыли на уровне level, начиная с 1.
+func actionTakeProfit(level int) string {
+	return fmt.Sprintf("tp%d", level)
+}
+
+// parseTakeProfitLevel возвращает уровень фиксации прибыли действия action.
+func parseTakeProfitLevel(action string) (int, bool) {
+	level, ok := strings.CutPrefix(action, "tp")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(level)
+	return n, err == nil
+}
+
+// newPositionID ID позиции side, открываемой в момент at.
+func newPositionID(side TradingPosition, at time.Time) string {
+	return string(side[:1]) + strconv.FormatInt(at.Unix(), 36)
+}
+
+// fallbackPositionID ID позиции, открытой не в этом запуске бота: строится
+// по цене входа, поэтому совпадает между перезапусками.
+func fallbackPositionID(leg PositionLeg) string {
+	return string(leg.Side[:1]) + "p" + strconv.FormatInt(int64(math.Round(leg.EntryPrice*100)), 36)
+}
+
+// clientOrderID ID ордера действия action по позиции positionID.
+func clientOrderID(cfg *Config, positionID, action string) string {
+	id := ownOrderPrefix(cfg) + positionID + "-" + action
+	if len(id) > maxClientOrderIDLen {
+		id = id[:maxClientOrderIDLen]
+	}
+	return id
+}
+
+// ownOrderPrefix общее начало ID всех ордеров бота по валютной паре.
+func ownOrderPrefix(cfg *Config) string {
+	return cfg.BotID + "-" + cfg.Symbol + "-"
+}
+
+// isOwnOrder сообщает, выставлен ли ордер этим ботом, а не вручную.
+func isOwnOrder(cfg *Config, o *futures.Order) bool {
+	return strings.HasPrefix(o.ClientOrderID, ownOrderPrefix(cfg))
+}
+
+// validateOrderIDs проверяет, что ID ордеров бота помещаются в ограничение биржи.
+func validateOrderIDs(cfg *Config) error {
+	if !botIDPattern.MatchString(cfg.BotID) {
+		return fmt.Errorf("botId must be 1-8 letters, digits or _, got %q", cfg.BotID)
+	}
+	if n := len(ownOrderPrefix(cfg)) + maxPositionIDLen + 1 + len(ActionClose); n > maxClientOrderIDLen {
+		return fmt.Errorf("botId and symbol are too long for client order id: %d > %d", n, maxClientOrderIDLen)
+	}
+	return nil
+}
+
+// binanceOrderByClientID ищет ордер по ID clientID, nil - ордера нет.
+func binanceOrderByClientID(ctx context.Context, bc *BinanceClient, symbol, clientID string) (*futures.Order, error) {
+	var order *futures.Order
+	err := bc.call(ctx, true, func() (err error) {
+		order, err = bc.NewGetOrderService().Symbol(symbol).OrigClientOrderID(clientID).Do(ctx, bc.recvWindow())
+		return err
+	})
+	var apiErr *common.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == codeOrderNotExist {
+		return nil, nil
+	}
+	return order, err
+}
+
+// orderLookupSince время сервера, начиная с которого ордер, найденный после
+// отправки в момент now, считается принятым этой отправкой. timeOffset -
+// опережение локальных часов относительно биржи в миллисекундах, секунда
+// запаса покрывает погрешность синхронизации.
+func orderLookupSince(now time.Time, timeOffset int64) int64 {
+	return now.UnixMilli() - timeOffset - time.Second.Milliseconds()
+}
+
+// binanceSubmitOrder отправляет ордер с ID clientID и возвращает ID ордера
+// на бирже. Если ответ биржи не получен, ордер ищется по clientID и
+// отправляется повторно, только если биржа его не приняла.
+func binanceSubmitOrder(ctx context.Context, bc *BinanceClient, symbol, clientID string, submit func() (int64, error)) (int64, error) {
+	// ордер с тем же ID мог остаться от прошлого шага, он старше отправки
+	since := orderLookupSince(time.Now(), bc.timeOffset())
+
+	for attempt := 0; ; attempt++ {
+		var orderID int64
+		err := bc.call(ctx, false, func() (err error) {
+			orderID, err = submit()
+			return err
+		})
+		if err == nil || classifyError(err) != errorRetryable || attempt >= bc.cfg.MaxRetries {
+			return orderID, err
+		}
+
+		order, lookupErr := binanceOrderByClientID(ctx, bc, symbol, clientID)
+		if lookupErr != nil {
+			return 0, errors.Join(err, lookupErr)
+		}
+		if order != nil && order.Time >= since {
+			fmt.Printf("Ордер %s принят биржей, несмотря на ошибку: %v\n", clientID, err)
+			return order.OrderID, nil
+		}
+
+		delay := bc.backoff(attempt, errorRetryable)
+		log.Printf("order %s not found on exchange, resend %d/%d in %s: %v", clientID, attempt+1, bc.cfg.MaxRetries, delay, err)
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrderLookupSince(t *testing.T) {
+	now := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		timeOffset time.Duration
+		// orderAge время принятия ордера по часам биржи относительно
+		// момента отправки
+		orderAge time.Duration
+		want     bool
+	}{
+		{"clocks in sync, just accepted", 0, 100 * time.Millisecond, true},
+		{"clocks in sync, left from previous tick", 0, -time.Minute, false},
+		{"local clock ahead, just accepted", 5 * time.Second, 100 * time.Millisecond, true},
+		{"local clock ahead, left from previous tick", 5 * time.Second, -time.Minute, false},
+		{"local clock behind, just accepted", -5 * time.Second, 100 * time.Millisecond, true},
+		{"local clock behind, left from previous tick", -5 * time.Second, -time.Minute, false},
+		{"within sync tolerance", 0, -500 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			since := orderLookupSince(now, tt.timeOffset.Milliseconds())
+			orderTime := now.Add(-tt.timeOffset).Add(tt.orderAge).UnixMilli()
+			if got := orderTime >= since; got != tt.want {
+				t.Errorf("order at %d accepted = %t since %d, want %t", orderTime, got, since, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientOrderIDRoundTrip(t *testing.T) {
+	cfg := &Config{BotID: "bot1", Symbol: "ETHUSDT"}
+	posID := newPositionID(LONG, time.Unix(1700000000, 0))
+
+	tests := []struct {
+		action string
+		level  int
+		isTP   bool
+	}{
+		{ActionEntry, 0, false},
+		{ActionStopOrder, 0, false},
+		{ActionClose, 0, false},
+		{actionTakeProfit(1), 1, true},
+		{actionTakeProfit(12), 12, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			id := clientOrderID(cfg, posID, tt.action)
+			if len(id) > maxClientOrderIDLen {
+				t.Fatalf("%q is longer than %d", id, maxClientOrderIDLen)
+			}
+			gotPos, gotAction, ok := parseClientOrderID(cfg, id)
+			if !ok || gotPos != posID || gotAction != tt.action {
+				t.Fatalf("parseClientOrderID(%q) = %q, %q, %t", id, gotPos, gotAction, ok)
+			}
+			level, isTP := parseTakeProfitLevel(gotAction)
+			if isTP != tt.isTP || level != tt.level {
+				t.Errorf("parseTakeProfitLevel(%q) = %d, %t", gotAction, level, isTP)
+			}
+		})
+	}
+}
+
+func TestParseClientOrderIDForeign(t *testing.T) {
+	cfg := &Config{BotID: "bot1", Symbol: "ETHUSDT"}
+
+	for _, id := range []string{
+		"",
+		"web_abc123",
+		"bot2-ETHUSDT-l123-open",
+		"bot1-BTCUSDT-l123-open",
+		"bot1-ETHUSDT-nodash",
+	} {
+		if pos, action, ok := parseClientOrderID(cfg, id); ok {
+			t.Errorf("parseClientOrderID(%q) = %q, %q, want not own", id, pos, action)
+		}
+	}
+}
+
+func TestPositionIDs(t *testing.T) {
+	at := time.Now()
+	for _, side := range []TradingPosition{LONG, SHORT} {
+		id := newPositionID(side, at)
+		if !strings.HasPrefix(id, string(side[:1])) || len(id) > maxPositionIDLen {
+			t.Errorf("newPositionID(%s) = %q", side, id)
+		}
+		fallback := fallbackPositionID(PositionLeg{Side: side, EntryPrice: 3456.78})
+		if fallback != fallbackPositionID(PositionLeg{Side: side, EntryPrice: 3456.78}) || len(fallback) > maxPositionIDLen {
+			t.Errorf("fallbackPositionID(%s) = %q", side, fallback)
+		}
+	}
+}
+
+func TestValidateOrderIDs(t *testing.T) {
+	tests := []struct {
+		botID   string
+		symbol  string
+		wantErr bool
+	}{
+		{"bot1", "ETHUSDT", false},
+		{"", "ETHUSDT", true},
+		{"bot-1", "ETHUSDT", true},
+		{"verylongbot", "ETHUSDT", true},
+		{"bot12345", "1000SHIBUSDCPERP", true},
+	}
+	for _, tt := range tests {
+		err := validateOrderIDs(&Config{BotID: tt.botID, Symbol: tt.symbol})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateOrderIDs(%q, %q) error = %v, want error %t", tt.botID, tt.symbol, err, tt.wantErr)
+		}
+	}
+}
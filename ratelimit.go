@@ -0,0 +1,311 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig лимиты запросов к бирже.
+type RateLimitConfig struct {
+	// WeightPerMinute лимит веса запросов с IP за минуту.
+	WeightPerMinute int `mapstructure:"weightPerMinute"`
+	// OrdersPerMinute лимит ордеров счета за минуту.
+	OrdersPerMinute int `mapstructure:"ordersPerMinute"`
+	// OrdersPer10s лимит ордеров счета за 10 секунд.
+	OrdersPer10s int `mapstructure:"ordersPer10s"`
+	// Headroom доля лимита, которую ограничитель оставляет свободной.
+	Headroom float64 `mapstructure:"headroom"`
+	// MaxWait максимальное время ожидания свободного лимита, после
+	// которого запрос завершается ошибкой.
+	MaxWait time.Duration `mapstructure:"maxWait"`
+}
+
+// RateLimitError запрос не отправлен, так как лимит не освободится
+// в течение RateLimitConfig.MaxWait.
+type RateLimitError struct {
+	Until time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit: requests blocked until %s", e.Until.Local().Format(time.TimeOnly))
+}
+
+// RateLimitMetrics текущее использование лимитов.
+type RateLimitMetrics struct {
+	UsedWeight     int       `json:"usedWeight"`
+	WeightLimit    int       `json:"weightLimit"`
+	WeightHeadroom int       `json:"weightHeadroom"`
+	Orders1m       int       `json:"orders1m"`
+	Orders10s      int       `json:"orders10s"`
+	Throttled      int       `json:"throttled"`
+	BlockedUntil   time.Time `json:"blockedUntil"`
+}
+
+// RateLimiter ограничитель запросов к бирже. Работает как http.RoundTripper
+// клиента: перед запросом резервирует его вес и кол-во ордеров, при нехватке
+// лимита ждет начала следующего окна, после ответа уточняет использование
+// по заголовкам X-MBX-USED-WEIGHT-1M и X-MBX-ORDER-COUNT-*.
+type RateLimiter struct {
+	cfg  RateLimitConfig
+	next http.RoundTripper
+
+	mu           sync.Mutex
+	weightWindow time.Time
+	usedWeight   int
+	orders1mWin  time.Time
+	orders1m     int
+	orders10sWin time.Time
+	orders10s    int
+	throttled    int
+	blockedUntil time.Time
+}
+
+// NewRateLimiter создает ограничитель поверх транспорта next.
+func NewRateLimiter(cfg RateLimitConfig, next http.RoundTripper) *RateLimiter {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &RateLimiter{cfg: cfg, next: next}
+}
+
+// RoundTrip реализует http.RoundTripper.
+func (rl *RateLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	weight := endpointWeight(req)
+	orders := endpointOrders(req)
+
+	for throttled := false; ; throttled = true {
+		wait, err := rl.reserve(weight, orders, time.Now())
+		if (err != nil || wait > 0) && !throttled {
+			// запрос учитывается один раз, сколько бы раз он ни ждал
+			rl.countThrottled()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if wait == 0 {
+			break
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+
+	res, err := rl.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	rl.update(res, time.Now())
+
+	return res, nil
+}
+
+// reserve резервирует лимит под запрос. Возвращает время ожидания,
+// если лимита сейчас недостаточно.
+func (rl *RateLimiter) reserve(weight, orders int, now time.Time) (time.Duration, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.rollWindows(now)
+
+	until := rl.blockedUntil
+	if limit := rl.allowed(rl.cfg.WeightPerMinute); limit > 0 && rl.usedWeight+weight > limit {
+		until = maxTime(until, rl.weightWindow.Add(time.Minute))
+	}
+	if orders > 0 {
+		if limit := rl.allowed(rl.cfg.OrdersPerMinute); limit > 0 && rl.orders1m+orders > limit {
+			until = maxTime(until, rl.orders1mWin.Add(time.Minute))
+		}
+		if limit := rl.allowed(rl.cfg.OrdersPer10s); limit > 0 && rl.orders10s+orders > limit {
+			until = maxTime(until, rl.orders10sWin.Add(10*time.Second))
+		}
+	}
+
+	if wait := until.Sub(now); wait > 0 {
+		if wait > rl.cfg.MaxWait {
+			return 0, &RateLimitError{Until: until}
+		}
+		return wait, nil
+	}
+
+	rl.usedWeight += weight
+	rl.orders1m += orders
+	rl.orders10s += orders
+
+	return 0, nil
+}
+
+// countThrottled учитывает запрос, задержанный ограничителем.
+func (rl *RateLimiter) countThrottled() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.throttled++
+}
+
+// update уточняет использование лимитов по заголовкам ответа и учитывает
+// блокировку при ответах 429 и 418.
+func (rl *RateLimiter) update(res *http.Response, now time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.rollWindows(now)
+
+	if v, err := strconv.Atoi(res.Header.Get("X-MBX-USED-WEIGHT-1M")); err == nil {
+		rl.usedWeight = v
+	}
+	if v, err := strconv.Atoi(res.Header.Get("X-MBX-ORDER-COUNT-1M")); err == nil {
+		rl.orders1m = v
+	}
+	if v, err := strconv.Atoi(res.Header.Get("X-MBX-ORDER-COUNT-10S")); err == nil {
+		rl.orders10s = v
+	}
+
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusTeapot {
+		retryAfter := time.Minute
+		if v, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(v) * time.Second
+		}
+		rl.blockedUntil = maxTime(rl.blockedUntil, now.Add(retryAfter))
+		if res.StatusCode == http.StatusTeapot {
+			alert(fmt.Sprintf("IP заблокирован биржей до %s за превышение лимитов", rl.blockedUntil.Local().Format(time.DateTime)))
+		}
+	}
+}
+
+// rollWindows начинает новые окна лимитов, если текущие истекли.
+func (rl *RateLimiter) rollWindows(now time.Time) {
+	if w := now.Truncate(time.Minute); !w.Equal(rl.weightWindow) {
+		rl.weightWindow, rl.usedWeight = w, 0
+	}
+	if w := now.Truncate(time.Minute); !w.Equal(rl.orders1mWin) {
+		rl.orders1mWin, rl.orders1m = w, 0
+	}
+	if w := now.Truncate(10 * time.Second); !w.Equal(rl.orders10sWin) {
+		rl.orders10sWin, rl.orders10s = w, 0
+	}
+}
+
+// allowed доступная часть лимита с учетом запаса.
+func (rl *RateLimiter) allowed(limit int) int {
+	return int(float64(limit) * (1 - rl.cfg.Headroom))
+}
+
+// Metrics возвращает текущее использование лимитов.
+func (rl *RateLimiter) Metrics() RateLimitMetrics {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.rollWindows(time.Now())
+
+	return RateLimitMetrics{
+		UsedWeight:     rl.usedWeight,
+		WeightLimit:    rl.cfg.WeightPerMinute,
+		WeightHeadroom: rl.cfg.WeightPerMinute - rl.usedWeight,
+		Orders1m:       rl.orders1m,
+		Orders10s:      rl.orders10s,
+		Throttled:      rl.throttled,
+		BlockedUntil:   rl.blockedUntil,
+	}
+}
+
+// printRateLimitMetrics выводит использование лимитов.
+func printRateLimitMetrics(m RateLimitMetrics) {
+	fmt.Printf("Вес запросов: %d из %d, запас %d\n", m.UsedWeight, m.WeightLimit, m.WeightHeadroom)
+	fmt.Printf("Ордеров: %d за минуту, %d за 10 секунд\n", m.Orders1m, m.Orders10s)
+	if m.Throttled > 0 {
+		fmt.Printf("Запросов задержано ограничителем: %d\n", m.Throttled)
+	}
+	if m.BlockedUntil.After(time.Now()) {
+		fmt.Println("Запросы заблокированы биржей до:", m.BlockedUntil.Local().Format(time.DateTime))
+	}
+}
+
+// serveMetrics публикует метрики ограничителя в expvar и запускает
+// HTTP-сервер, отдающий их по адресу /debug/vars.
+func serveMetrics(addr string, rl *RateLimiter) {
+	expvar.Publish("rateLimits", expvar.Func(func() any {
+		return rl.Metrics()
+	}))
+
+	go func() {
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Println("metrics server:", err)
+		}
+	}()
+}
+
+// endpointWeight вес запроса к эндпоинту фьючерсного API.
+func endpointWeight(req *http.Request) int {
+	path := req.URL.Path
+	query := req.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	switch {
+	case path == "/fapi/v1/klines":
+		switch {
+		case limit > 0 && limit < 100:
+			return 1
+		case limit > 0 && limit < 500:
+			return 2
+		case limit > 0 && limit <= 1000:
+			return 5
+		}
+		return 10
+	case path == "/fapi/v1/depth":
+		switch {
+		case limit > 0 && limit <= 50:
+			return 2
+		case limit == 100:
+			return 5
+		case limit == 0 || limit == 500:
+			return 10
+		}
+		return 20
+	case path == "/fapi/v1/openOrders":
+		if query.Get("symbol") == "" {
+			return 40
+		}
+		return 1
+	case path == "/fapi/v1/ticker/price" || path == "/fapi/v2/ticker/price" || path == "/fapi/v1/ticker/bookTicker":
+		if query.Get("symbol") == "" {
+			return 5
+		}
+		return 2
+	case path == "/fapi/v1/income" || path == "/fapi/v1/positionSide/dual":
+		return 30
+	case path == "/fapi/v1/batchOrders" || path == "/fapi/v1/allOrders" || path == "/fapi/v1/userTrades" ||
+		strings.HasPrefix(path, "/fapi/v2/account") || strings.HasPrefix(path, "/fapi/v2/positionRisk"):
+		return 5
+	}
+
+	return 1
+}
+
+// endpointOrders кол-во ордеров, которое запрос добавляет к лимиту счета.
+func endpointOrders(req *http.Request) int {
+	if req.Method != http.MethodPost {
+		return 0
+	}
+	switch req.URL.Path {
+	case "/fapi/v1/order":
+		return 1
+	case "/fapi/v1/batchOrders":
+		// в пакете не более 5 ордеров
+		return 5
+	}
+	return 0
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
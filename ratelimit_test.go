@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// roundTripFunc транспорт, ответ которого возвращает функция.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	// середина минуты и 10-секундного окна
+	now := time.Date(2024, 3, 5, 12, 30, 25, 0, time.UTC)
+	minute, tenSec := now.Truncate(time.Minute), now.Truncate(10*time.Second)
+
+	tests := []struct {
+		name       string
+		cfg        RateLimitConfig
+		usedWeight int
+		orders1m   int
+		orders10s  int
+		blocked    time.Time
+		// last время предыдущего запроса, от него отсчитываются окна
+		last     time.Time
+		weight   int
+		orders   int
+		wantWait time.Duration
+		wantErr  bool
+	}{
+		{name: "within limits", cfg: RateLimitConfig{WeightPerMinute: 100, OrdersPer10s: 5}, usedWeight: 50, orders10s: 2, weight: 10, orders: 1},
+		{name: "no limits", weight: 1000, orders: 1000},
+		{name: "weight exhausted", cfg: RateLimitConfig{WeightPerMinute: 100, MaxWait: time.Minute}, usedWeight: 95, weight: 10, wantWait: 35 * time.Second},
+		{name: "headroom", cfg: RateLimitConfig{WeightPerMinute: 100, Headroom: 0.2, MaxWait: time.Minute}, usedWeight: 75, weight: 10, wantWait: 35 * time.Second},
+		{name: "orders per 10s", cfg: RateLimitConfig{OrdersPer10s: 5, MaxWait: time.Minute}, orders10s: 5, orders: 1, wantWait: 5 * time.Second},
+		{name: "orders per minute", cfg: RateLimitConfig{OrdersPerMinute: 10, OrdersPer10s: 5, MaxWait: time.Minute}, orders1m: 10, orders: 1, wantWait: 35 * time.Second},
+		{name: "order limits skip queries", cfg: RateLimitConfig{OrdersPer10s: 5}, orders10s: 5, weight: 1},
+		{name: "blocked by exchange", cfg: RateLimitConfig{MaxWait: time.Minute}, blocked: now.Add(20 * time.Second), weight: 1, wantWait: 20 * time.Second},
+		{name: "wait exceeds max", cfg: RateLimitConfig{WeightPerMinute: 100, MaxWait: 10 * time.Second}, usedWeight: 100, weight: 1, wantErr: true},
+		{name: "new window", cfg: RateLimitConfig{WeightPerMinute: 100, OrdersPer10s: 5}, usedWeight: 100, orders10s: 5, last: now.Add(-time.Minute), weight: 10, orders: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := NewRateLimiter(tt.cfg, nil)
+			last := tt.last
+			if last.IsZero() {
+				last = now
+			}
+			rl.rollWindows(last)
+			rl.usedWeight, rl.orders1m, rl.orders10s, rl.blockedUntil = tt.usedWeight, tt.orders1m, tt.orders10s, tt.blocked
+
+			wait, err := rl.reserve(tt.weight, tt.orders, now)
+			var rlErr *RateLimitError
+			if errors.As(err, &rlErr) != tt.wantErr {
+				t.Fatalf("reserve error = %v, want error %t", err, tt.wantErr)
+			}
+			if wait != tt.wantWait {
+				t.Errorf("wait = %s, want %s", wait, tt.wantWait)
+			}
+
+			// лимит резервируется только для отправленного запроса
+			wantWeight, want1m, want10s := tt.usedWeight, tt.orders1m, tt.orders10s
+			if !tt.last.IsZero() {
+				wantWeight, want1m, want10s = 0, 0, 0
+			}
+			if wait == 0 && err == nil {
+				wantWeight, want1m, want10s = wantWeight+tt.weight, want1m+tt.orders, want10s+tt.orders
+			}
+			if rl.usedWeight != wantWeight || rl.orders1m != want1m || rl.orders10s != want10s {
+				t.Errorf("used weight %d, orders %d/%d, want %d, %d/%d", rl.usedWeight, rl.orders1m, rl.orders10s, wantWeight, want1m, want10s)
+			}
+			if !rl.weightWindow.Equal(minute) || !rl.orders10sWin.Equal(tenSec) {
+				t.Errorf("windows %s, %s, want %s, %s", rl.weightWindow, rl.orders10sWin, minute, tenSec)
+			}
+		})
+	}
+}
+
+func TestRateLimiterUpdate(t *testing.T) {
+	now := time.Date(2024, 3, 5, 12, 30, 25, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		status      int
+		headers     map[string]string
+		wantWeight  int
+		wantOrders  int
+		wantBlocked time.Time
+	}{
+		// биржа учитывает и запросы других клиентов с этого IP
+		{name: "weight from header", status: http.StatusOK, headers: map[string]string{"X-MBX-USED-WEIGHT-1M": "250"}, wantWeight: 250, wantOrders: 3},
+		{name: "lower weight from header", status: http.StatusOK, headers: map[string]string{"X-MBX-USED-WEIGHT-1M": "5", "X-MBX-ORDER-COUNT-10S": "1"}, wantWeight: 5, wantOrders: 1},
+		{name: "no headers", status: http.StatusOK, wantWeight: 40, wantOrders: 3},
+		{name: "invalid header", status: http.StatusOK, headers: map[string]string{"X-MBX-USED-WEIGHT-1M": "n/a"}, wantWeight: 40, wantOrders: 3},
+		{name: "too many requests", status: http.StatusTooManyRequests, headers: map[string]string{"Retry-After": "30"}, wantWeight: 40, wantOrders: 3, wantBlocked: now.Add(30 * time.Second)},
+		{name: "banned without retry after", status: http.StatusTeapot, wantWeight: 40, wantOrders: 3, wantBlocked: now.Add(time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := NewRateLimiter(RateLimitConfig{}, nil)
+			rl.rollWindows(now)
+			rl.usedWeight, rl.orders10s = 40, 3
+
+			rec := httptest.NewRecorder()
+			for k, v := range tt.headers {
+				rec.Header().Set(k, v)
+			}
+			rec.WriteHeader(tt.status)
+			rl.update(rec.Result(), now)
+
+			if rl.usedWeight != tt.wantWeight || rl.orders10s != tt.wantOrders {
+				t.Errorf("used weight %d, orders %d, want %d, %d", rl.usedWeight, rl.orders10s, tt.wantWeight, tt.wantOrders)
+			}
+			if !rl.blockedUntil.Equal(tt.wantBlocked) {
+				t.Errorf("blocked until %s, want %s", rl.blockedUntil, tt.wantBlocked)
+			}
+		})
+	}
+}
+
+func TestRateLimiterThrottled(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConfig{MaxWait: time.Second}, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return httptest.NewRecorder().Result(), nil
+	}))
+	send := func() {
+		req := httptest.NewRequest(http.MethodGet, "https://fapi.binance.com/fapi/v1/time", nil)
+		if _, err := rl.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	block := func(d time.Duration) {
+		rl.mu.Lock()
+		rl.blockedUntil = time.Now().Add(d)
+		rl.mu.Unlock()
+	}
+
+	send()
+	if m := rl.Metrics(); m.Throttled != 0 {
+		t.Fatalf("Throttled = %d without waiting", m.Throttled)
+	}
+
+	// пока запрос ждет, блокировка продлевается: он ждет второй раз
+	block(20 * time.Millisecond)
+	go func() {
+		for rl.Metrics().Throttled == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		block(40 * time.Millisecond)
+	}()
+	start := time.Now()
+	send()
+	if waited := time.Since(start); waited < 30*time.Millisecond {
+		t.Fatalf("request waited %s, want the extended block", waited)
+	}
+	if m := rl.Metrics(); m.Throttled != 1 {
+		t.Errorf("Throttled = %d after one delayed request", m.Throttled)
+	}
+
+	block(5 * time.Millisecond)
+	send()
+	if m := rl.Metrics(); m.Throttled != 2 {
+		t.Errorf("Throttled = %d after two delayed requests", m.Throttled)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/adshao/go-binance/v2/futures"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// recentOrdersLimit кол-во последних ордеров, по которым восстанавливается
+// история позиций.
+const recentOrdersLimit = 1000
+
+// parseClientOrderID разбирает ID ордера бота на ID позиции и действие.
+func parseClientOrderID(cfg *Config, clientID string) (positionID, action string, ok bool) {
+	rest, ok := strings.CutPrefix(clientID, ownOrderPrefix(cfg))
+	if !ok {
+		return "", "", false
+	}
+	positionID, action, ok = strings.Cut(rest, "-")
+	return positionID, action, ok
+}
+
+// quantityEpsilon погрешность сравнения объемов позиции.
+const quantityEpsilon = 1e-9
+
+// executedQuantity исполненный объем ордера.
+func executedQuantity(o *futures.Order) float64 {
+	executed, _ := strconv.ParseFloat(o.ExecutedQuantity, 64)
+	return executed
+}
+
+// orderExecuted сообщает, исполнен ли ордер хотя бы частично.
+func orderExecuted(o *futures.Order) bool {
+	return executedQuantity(o) > 0
+}
+
+// matchBotEntry ищет среди последних ордеров recent вход бота, которым
+// открыта сторона позиции leg: последний исполненный вход в эту сторону,
+// после которого позиция не закрывалась, а объем входа за вычетом
+// фиксаций прибыли и сокращений совпадает с объемом стороны.
+// nil - сторона открыта не ботом.
+func matchBotEntry(cfg *Config, recent []*futures.Order, leg PositionLeg) *futures.Order {
+	var entry *futures.Order
+	for _, o := range recent {
+		id, action, ok := parseClientOrderID(cfg, o.ClientOrderID)
+		if ok && action == ActionEntry && strings.HasPrefix(id, string(leg.Side[:1])) && orderExecuted(o) &&
+			(entry == nil || o.UpdateTime > entry.UpdateTime) {
+			entry = o
+		}
+	}
+	if entry == nil {
+		return nil
+	}
+
+	positionID, _, _ := parseClientOrderID(cfg, entry.ClientOrderID)
+	remaining := executedQuantity(entry)
+	for _, o := range recent {
+		id, action, ok := parseClientOrderID(cfg, o.ClientOrderID)
+		if !ok || id != positionID || o == entry || !orderExecuted(o) {
+			continue
+		}
+		if _, tp := parseTakeProfitLevel(action); tp || action == ActionLiquidation {
+			remaining -= executedQuantity(o)
+			continue
+		}
+		switch action {
+		case ActionStop, ActionStopOrder, ActionFunding, ActionClose:
+			// позиция этого входа закрыта
+			return nil
+		}
+	}
+	if math.Abs(remaining-leg.Amount) > quantityEpsilon {
+		return nil
+	}
+	return entry
+}
+
+// reconcile сверяет позиции, открытые ордера и последние ордера на бирже
+// с сохраненным состоянием бота: восстанавливает ID позиций и пройденные
+// уровни фиксации прибыли, отменяет ордера бота без позиции и выставляет
+// недостающие стоп-лоссы. Каждое расхождение выводится.
+func reconcile(ctx context.Context, bc *BinanceClient, state *TradeState, cfg *Config) error {
+	pos, err := binanceOpenedPositions(ctx, bc, cfg.Symbol)
+	if err != nil {
+		return err
+	}
+
+	var open, recent []*futures.Order
+	err = bc.call(ctx, true, func() (err error) {
+		open, err = bc.NewListOpenOrdersService().Symbol(cfg.Symbol).Do(ctx, bc.recvWindow())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = bc.call(ctx, true, func() (err error) {
+		recent, err = bc.NewListOrdersService().Symbol(cfg.Symbol).Limit(recentOrdersLimit).Do(ctx, bc.recvWindow())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	issues := 0
+	report := func(format string, args ...any) {
+		issues++
+		fmt.Println("Расхождение:", fmt.Sprintf(format, args...))
+	}
+
+	// позиции
+	restored := make(map[TradingPosition]bool)
+	for _, side := range []TradingPosition{LONG, SHORT} {
+		ls, leg := state.Leg(side), pos.Leg(side)
+		switch {
+		case ls != nil && leg == nil:
+			report("позиция %s %s есть в состоянии бота, но закрыта на бирже", side, ls.PositionID)
+		case ls == nil && leg != nil:
+			report("позиция %s %f открыта на бирже, но отсутствует в состоянии бота", side, leg.Amount)
+			restored[side] = true
+		case ls != nil && ls.EntryPrice != leg.EntryPrice:
+			report("цена входа %s: %f в состоянии бота, %f на бирже", side, ls.EntryPrice, leg.EntryPrice)
+		}
+	}
+	state.Sync(pos, cfg.StopPercent)
+
+	for _, leg := range pos.Legs {
+		ls := state.Leg(leg.Side)
+
+		// ID позиции без состояния восстанавливается по входу бота,
+		// иначе сторона считается открытой вручную
+		if restored[leg.Side] {
+			if entry := matchBotEntry(cfg, recent, leg); entry != nil {
+				ls.PositionID, _, _ = parseClientOrderID(cfg, entry.ClientOrderID)
+				ls.EntryAmount = executedQuantity(entry)
+				fmt.Printf("Позиция %s восстановлена по ордеру %s\n", leg.Side, entry.ClientOrderID)
+			} else {
+				report("позиция %s %f открыта не ботом и не будет сопровождаться", leg.Side, leg.Amount)
+				ls.Manual = true
+				ls.Ladder = nil
+			}
+		}
+		if ls.Manual {
+			continue
+		}
+
+		// пройденные уровни фиксации прибыли
+		hit := 0
+		for _, o := range recent {
+			id, action, ok := parseClientOrderID(cfg, o.ClientOrderID)
+			if !ok || id != ls.PositionID || !orderExecuted(o) {
+				continue
+			}
+			if level, ok := parseTakeProfitLevel(action); ok {
+				hit = max(hit, level)
+			}
+		}
+		remaining := state.ladder[min(hit, len(state.ladder)):]
+		if len(ls.Ladder) != len(remaining) {
+			report("уровни фиксации прибыли %s: в состоянии бота осталось %d, по исполненным ордерам %d",
+				leg.Side, len(ls.Ladder), len(remaining))
+			ls.Ladder = append([][]int(nil), remaining...)
+		}
+	}
+
+	// открытые ордера
+	stops := make(map[TradingPosition]bool)
+	manual := 0
+	for _, o := range open {
+		id, action, ok := parseClientOrderID(cfg, o.ClientOrderID)
+		if !ok {
+			manual++
+			continue
+		}
+
+		var leg *PositionLeg
+		for i := range pos.Legs {
+			if state.Leg(pos.Legs[i].Side).PositionID == id {
+				leg = &pos.Legs[i]
+			}
+		}
+		if leg == nil || (action == ActionStopOrder && stops[leg.Side]) {
+			report("ордер %s не относится к открытой позиции, отменен", o.ClientOrderID)
+			if err = binanceCancelOrder(ctx, bc, cfg.Symbol, o.OrderID); err != nil {
+				return err
+			}
+			continue
+		}
+		if action != ActionStopOrder {
+			continue
+		}
+
+		ls := state.Leg(leg.Side)
+		stops[leg.Side] = true
+		if ls.StopOrderID != o.OrderID {
+			report("стоп-лосс %s на бирже %d, в состоянии бота %d", leg.Side, o.OrderID, ls.StopOrderID)
+			ls.StopOrderID = o.OrderID
+		}
+		if stopPrice, _ := strconv.ParseFloat(o.StopPrice, 64); fmt.Sprintf("%.2f", stopPrice) != fmt.Sprintf("%.2f", ls.StopPrice) {
+			report("цена стоп-лосса %s: %.2f на бирже, %.2f в состоянии бота", leg.Side, stopPrice, ls.StopPrice)
+			ls.StopMoved = true
+		}
+	}
+	if manual > 0 {
+		fmt.Printf("Ордеров, выставленных вручную: %d\n", manual)
+	}
+
+	// недостающие стоп-лоссы
+	for _, leg := range pos.Legs {
+		ls := state.Leg(leg.Side)
+		if ls.Manual {
+			continue
+		}
+		if !stops[leg.Side] {
+			if ls.StopOrderID != 0 {
+				report("стоп-лосс %s %d отсутствует на бирже", leg.Side, ls.StopOrderID)
+			} else {
+				report("стоп-лосс %s не выставлен", leg.Side)
+			}
+			ls.StopOrderID = 0
+		}
+		if err = syncStopOrder(ctx, bc, leg, ls, pos.Hedge, cfg); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Сверка с биржей завершена, расхождений: %d\n", issues)
+	return state.Save()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/adshao/go-binance/v2/futures"
+	"testing"
+)
+
+func TestMatchBotEntry(t *testing.T) {
+	cfg := &Config{BotID: "bot1", Symbol: "ETHUSDT"}
+	order := func(positionID, action, executed string, updateTime int64) *futures.Order {
+		return &futures.Order{
+			ClientOrderID:    clientOrderID(cfg, positionID, action),
+			ExecutedQuantity: executed,
+			UpdateTime:       updateTime,
+		}
+	}
+	long := PositionLeg{Side: LONG, Amount: 0.03}
+
+	tests := []struct {
+		name   string
+		recent []*futures.Order
+		leg    PositionLeg
+		want   string
+	}{
+		{
+			name:   "open entry",
+			recent: []*futures.Order{order("l1", ActionEntry, "0.03", 1)},
+			leg:    long,
+			want:   "l1",
+		},
+		{
+			name: "latest entry after take profits",
+			recent: []*futures.Order{
+				order("l1", ActionEntry, "0.03", 1),
+				order("l1", ActionStopOrder, "0.03", 2),
+				order("l3", ActionEntry, "0.05", 3),
+				order("l3", actionTakeProfit(1), "0.02", 4),
+			},
+			leg:  long,
+			want: "l3",
+		},
+		{
+			name: "entry closed by stop",
+			recent: []*futures.Order{
+				order("l1", ActionEntry, "0.03", 1),
+				order("l1", ActionStopOrder, "0.03", 2),
+			},
+			leg: long,
+		},
+		{
+			name: "entry closed before funding",
+			recent: []*futures.Order{
+				order("l1", ActionEntry, "0.03", 1),
+				order("l1", ActionFunding, "0.03", 2),
+			},
+			leg: long,
+		},
+		{
+			name:   "amount differs from entry",
+			recent: []*futures.Order{order("l1", ActionEntry, "0.05", 1)},
+			leg:    long,
+		},
+		{
+			name:   "entry not executed",
+			recent: []*futures.Order{order("l1", ActionEntry, "0", 1)},
+			leg:    long,
+		},
+		{
+			name:   "entry on other side",
+			recent: []*futures.Order{order("s1", ActionEntry, "0.03", 1)},
+			leg:    long,
+		},
+		{
+			name: "foreign orders",
+			recent: []*futures.Order{
+				{ClientOrderID: "web_123", ExecutedQuantity: "0.03", UpdateTime: 1},
+				{ClientOrderID: "bot2-ETHUSDT-l1-open", ExecutedQuantity: "0.03", UpdateTime: 2},
+			},
+			leg: long,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if entry := matchBotEntry(cfg, tt.recent, tt.leg); entry != nil {
+				got, _, _ = parseClientOrderID(cfg, entry.ClientOrderID)
+			}
+			if got != tt.want {
+				t.Errorf("matchBotEntry = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
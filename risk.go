@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/adshao/go-binance/v2/futures"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// accountTradesWindow максимальный период одного запроса сделок счета.
+const accountTradesWindow = 7 * 24 * time.Hour
+
+// accountTradesLimit максимальное кол-во сделок в одном запросе.
+const accountTradesLimit = 1000
+
+// RiskConfig лимиты риска на уровне счета. Нулевое значение лимита
+// означает, что он не проверяется.
+type RiskConfig struct {
+	// MaxDailyLoss максимальный реализованный убыток за сутки (UTC).
+	MaxDailyLoss float64 `mapstructure:"maxDailyLoss"`
+	// MaxDrawdownPercent максимальная просадка капитала от пика в долях.
+	MaxDrawdownPercent float64 `mapstructure:"maxDrawdownPercent"`
+	// MaxConsecutiveLosses максимальное кол-во убыточных сделок подряд.
+	MaxConsecutiveLosses int `mapstructure:"maxConsecutiveLosses"`
+	// MaxOpenNotional максимальный объем открытой позиции в валюте котировки.
+	MaxOpenNotional float64 `mapstructure:"maxOpenNotional"`
+	// MaxLeverage максимальное плечо: и установленное на бирже,
+	// и фактическое отношение объема позиции к капиталу.
+	MaxLeverage float64 `mapstructure:"maxLeverage"`
+	// FlattenOnHalt закрывать открытую позицию при срабатывании лимита.
+	// Стороны, открытые вручную, не закрываются.
+	FlattenOnHalt bool `mapstructure:"flattenOnHalt"`
+	// ResetOnNewSession снимать блокировку в начале следующих суток,
+	// иначе только командой risk reset.
+	ResetOnNewSession bool `mapstructure:"resetOnNewSession"`
+	// StateFile файл для сохранения состояния между запусками.
+	StateFile string `mapstructure:"stateFile"`
+}
+
+// RiskState состояние риск-менеджера.
+type RiskState struct {
+	// Session сутки (UTC), к которым относится дневной убыток.
+	Session             string  `json:"session"`
+	SessionStartBalance float64 `json:"sessionStartBalance"`
+	PeakEquity          float64 `json:"peakEquity"`
+	ConsecutiveLosses   int     `json:"consecutiveLosses"`
+	// OpenLegs открытые стороны позиции и время, не позже которого
+	// они открыты.
+	OpenLegs map[TradingPosition]time.Time `json:"openLegs,omitempty"`
+	// UpdatedAt время последнего обновления.
+	UpdatedAt  time.Time `json:"updatedAt"`
+	Halted     bool      `json:"halted"`
+	HaltReason string    `json:"haltReason,omitempty"`
+	HaltedAt   time.Time `json:"haltedAt"`
+}
+
+// TradeResultFunc возвращает результат сделки по стороне позиции side,
+// открытой не раньше from: реализованную прибыль с учетом комиссий.
+type TradeResultFunc func(side TradingPosition, from time.Time) (float64, error)
+
+// RiskManager проверяет лимиты риска перед открытием позиций
+// и блокирует новые входы при их превышении.
+type RiskManager struct {
+	cfg   RiskConfig
+	state RiskState
+}
+
+// LoadRiskManager создает риск-менеджер и загружает сохраненное состояние.
+func LoadRiskManager(cfg RiskConfig) (*RiskManager, error) {
+	rm := &RiskManager{cfg: cfg}
+
+	b, err := os.ReadFile(cfg.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return rm, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &rm.state); err != nil {
+		return nil, fmt.Errorf("read risk state %s: %w", cfg.StateFile, err)
+	}
+
+	return rm, nil
+}
+
+// State возвращает текущее состояние.
+func (rm *RiskManager) State() RiskState {
+	return rm.state
+}
+
+// Update учитывает текущее состояние счета: начало новых суток, пик
+// капитала, открытие и закрытие сторон позиции. Результат закрытой
+// стороны запрашивается у result, каждая сторона считается отдельной
+// сделкой. Возвращает true, если после обновления новые входы заблокированы.
+func (rm *RiskManager) Update(pos *OpenedPosition, now time.Time, result TradeResultFunc) (bool, error) {
+	s := &rm.state
+	equity := pos.Balance + pos.Profit
+
+	session := now.UTC().Format(time.DateOnly)
+	if s.Session != session {
+		s.Session = session
+		s.SessionStartBalance = pos.Balance
+		if s.Halted && rm.cfg.ResetOnNewSession {
+			rm.reset()
+		}
+	}
+
+	if equity > s.PeakEquity {
+		s.PeakEquity = equity
+	}
+
+	if s.OpenLegs == nil {
+		s.OpenLegs = make(map[TradingPosition]time.Time)
+	}
+	for _, side := range []TradingPosition{LONG, SHORT} {
+		openedAt, ok := s.OpenLegs[side]
+		if !ok || pos.Leg(side) != nil {
+			continue
+		}
+		// сторона закрыта: результат сделки по ее исполнениям, без
+		// финансирования и сделок другой стороны
+		pnl, err := result(side, openedAt)
+		if err != nil {
+			// сторона останется в состоянии, результат запросится снова
+			return s.Halted, errors.Join(fmt.Errorf("%s trade result: %w", side, err), rm.save())
+		}
+		delete(s.OpenLegs, side)
+		if pnl < 0 {
+			s.ConsecutiveLosses++
+		} else {
+			s.ConsecutiveLosses = 0
+		}
+	}
+	for _, leg := range pos.Legs {
+		if _, ok := s.OpenLegs[leg.Side]; !ok {
+			// сторона открылась после прошлого обновления
+			openedAt := s.UpdatedAt
+			if openedAt.IsZero() {
+				openedAt = now
+			}
+			s.OpenLegs[leg.Side] = openedAt
+		}
+	}
+	s.UpdatedAt = now
+
+	if !s.Halted {
+		if reason := rm.checkLimits(equity, pos.Balance); reason != "" {
+			s.Halted = true
+			s.HaltReason = reason
+			s.HaltedAt = now
+		}
+	}
+
+	return s.Halted, rm.save()
+}
+
+func (rm *RiskManager) checkLimits(equity, balance float64) string {
+	s := rm.state
+
+	if dailyLoss := s.SessionStartBalance - balance; rm.cfg.MaxDailyLoss > 0 && dailyLoss >= rm.cfg.MaxDailyLoss {
+		return fmt.Sprintf("daily loss %.2f reached limit %.2f", dailyLoss, rm.cfg.MaxDailyLoss)
+	}
+	if rm.cfg.MaxDrawdownPercent > 0 && s.PeakEquity > 0 {
+		if dd := (s.PeakEquity - equity) / s.PeakEquity; dd >= rm.cfg.MaxDrawdownPercent {
+			return fmt.Sprintf("drawdown %.2f%% reached limit %.2f%%", dd*100, rm.cfg.MaxDrawdownPercent*100)
+		}
+	}
+	if rm.cfg.MaxConsecutiveLosses > 0 && s.ConsecutiveLosses >= rm.cfg.MaxConsecutiveLosses {
+		return fmt.Sprintf("%d consecutive losses reached limit %d", s.ConsecutiveLosses, rm.cfg.MaxConsecutiveLosses)
+	}
+
+	return ""
+}
+
+// CheckEntry проверяет, можно ли открыть позицию quantity по цене price.
+func (rm *RiskManager) CheckEntry(pos *OpenedPosition, quantity, price float64) error {
+	if rm.state.Halted {
+		return fmt.Errorf("trading halted: %s", rm.state.HaltReason)
+	}
+	if price <= 0 {
+		return fmt.Errorf("invalid entry price %f", price)
+	}
+
+	notional := (pos.GrossAmount() + quantity) * price
+	if rm.cfg.MaxOpenNotional > 0 && notional > rm.cfg.MaxOpenNotional {
+		return fmt.Errorf("open notional %.2f exceeds limit %.2f", notional, rm.cfg.MaxOpenNotional)
+	}
+
+	if rm.cfg.MaxLeverage > 0 {
+		if pos.Leverage > rm.cfg.MaxLeverage {
+			return fmt.Errorf("account leverage %.0f exceeds limit %.0f", pos.Leverage, rm.cfg.MaxLeverage)
+		}
+		equity := pos.Balance + pos.Profit
+		if equity <= 0 {
+			return fmt.Errorf("no equity to open position")
+		}
+		if leverage := notional / equity; leverage > rm.cfg.MaxLeverage {
+			return fmt.Errorf("effective leverage %.2f exceeds limit %.0f", leverage, rm.cfg.MaxLeverage)
+		}
+	}
+
+	return nil
+}
+
+// ShouldFlatten сообщает, нужно ли закрыть позицию из-за блокировки.
+func (rm *RiskManager) ShouldFlatten() bool {
+	return rm.state.Halted && rm.cfg.FlattenOnHalt
+}
+
+// Reset снимает блокировку и обнуляет счетчик убыточных сделок.
+// Дневной убыток считается заново от баланса при следующем обновлении.
+func (rm *RiskManager) Reset() error {
+	rm.reset()
+	rm.state.Session = ""
+	return rm.save()
+}
+
+func (rm *RiskManager) reset() {
+	rm.state.Halted = false
+	rm.state.HaltReason = ""
+	rm.state.HaltedAt = time.Time{}
+	rm.state.ConsecutiveLosses = 0
+	// просадка считается от капитала на момент снятия блокировки
+	rm.state.PeakEquity = 0
+}
+
+func (rm *RiskManager) save() error {
+	if rm.cfg.StateFile == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(rm.cfg.StateFile), 0o755); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(rm.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(rm.cfg.StateFile, b, 0o644)
+}
+
+// binanceTradeResult результат стороны позиции side по сделкам счета
+// с from по to: реализованная прибыль за вычетом комиссий. В режиме
+// хеджирования учитываются только сделки этой стороны. Комиссии в другой
+// валюте, например BNB, и финансирование в результат не входят.
+func binanceTradeResult(ctx context.Context, bc *BinanceClient, symbol string, side TradingPosition, hedge bool, from, to time.Time) (float64, error) {
+	ps := positionSide(side, hedge)
+	var result float64
+	// страницы пересекаются по времени последней сделки
+	seen := make(map[int64]bool)
+
+	for start := from.UnixMilli(); start <= to.UnixMilli(); {
+		// биржа отдает сделки не более чем за 7 дней за запрос
+		end := min(start+accountTradesWindow.Milliseconds()-1, to.UnixMilli())
+		var trades []*futures.AccountTrade
+		err := bc.call(ctx, true, func() (err error) {
+			trades, err = bc.NewListAccountTradeService().
+				Symbol(symbol).
+				StartTime(start).
+				EndTime(end).
+				Limit(accountTradesLimit).
+				Do(ctx, bc.recvWindow())
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		added := 0
+		for _, t := range trades {
+			if seen[t.ID] {
+				continue
+			}
+			seen[t.ID] = true
+			added++
+			if t.PositionSide != ps {
+				continue
+			}
+			pnl, _ := strconv.ParseFloat(t.RealizedPnl, 64)
+			result += pnl
+			if strings.HasSuffix(symbol, t.CommissionAsset) {
+				commission, _ := strconv.ParseFloat(t.Commission, 64)
+				result -= commission
+			}
+		}
+
+		switch {
+		case len(trades) < accountTradesLimit:
+			start = end + 1
+		case added > 0:
+			// окно не поместилось в страницу, продолжить с последней сделки
+			start = trades[len(trades)-1].Time
+		default:
+			// вся страница с одним временем, дальше по нему не продвинуться
+			start = trades[len(trades)-1].Time + 1
+		}
+	}
+
+	return result, nil
+}
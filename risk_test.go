@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/adshao/go-binance/v2/futures"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// riskStep состояние счета при очередном обновлении риск-менеджера.
+type riskStep struct {
+	at      time.Time
+	balance float64
+	profit  float64
+	legs    []TradingPosition
+}
+
+func (st riskStep) position() *OpenedPosition {
+	pos := &OpenedPosition{Balance: st.balance, Profit: st.profit, Hedge: len(st.legs) > 1}
+	for _, side := range st.legs {
+		pos.Legs = append(pos.Legs, PositionLeg{Side: side, Amount: 0.01})
+	}
+	return pos
+}
+
+func TestRiskManagerUpdate(t *testing.T) {
+	day := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	msk := time.FixedZone("MSK", 3*60*60)
+
+	tests := []struct {
+		name string
+		cfg  RiskConfig
+		// results результаты закрытых сторон по порядку закрытия
+		results    []float64
+		steps      []riskStep
+		wantHalted string
+		wantLosses int
+		wantStart  float64
+	}{
+		{
+			name:       "daily loss",
+			cfg:        RiskConfig{MaxDailyLoss: 50},
+			steps:      []riskStep{{at: day.Add(10 * time.Hour), balance: 1000}, {at: day.Add(20 * time.Hour), balance: 940}},
+			wantHalted: "daily loss 60.00",
+			wantStart:  1000,
+		},
+		{
+			// 02:30 и 03:30 по Москве - одни сутки по Москве, но разные UTC
+			name: "daily loss rolls over at UTC midnight",
+			cfg:  RiskConfig{MaxDailyLoss: 50},
+			steps: []riskStep{
+				{at: day.Add(10 * time.Hour), balance: 1000},
+				{at: day.Add(23*time.Hour + 30*time.Minute).In(msk), balance: 960},
+				{at: day.Add(24*time.Hour + 30*time.Minute).In(msk), balance: 930},
+				{at: day.Add(30 * time.Hour), balance: 900},
+			},
+			wantStart: 930,
+		},
+		{
+			name: "drawdown from peak",
+			cfg:  RiskConfig{MaxDrawdownPercent: 0.1},
+			steps: []riskStep{
+				{at: day, balance: 1000},
+				{at: day.Add(time.Hour), balance: 1000, profit: 200},
+				{at: day.Add(2 * time.Hour), balance: 1000, profit: 90},
+				{at: day.Add(3 * time.Hour), balance: 1000, profit: 70},
+			},
+			wantHalted: "drawdown 10.83%",
+			wantStart:  1000,
+		},
+		{
+			name: "drawdown within limit",
+			cfg:  RiskConfig{MaxDrawdownPercent: 0.1},
+			steps: []riskStep{
+				{at: day, balance: 1000, profit: 200},
+				{at: day.Add(time.Hour), balance: 1000, profit: 90},
+			},
+			wantStart: 1000,
+		},
+		{
+			// в режиме хеджирования закрытие одной стороны - отдельная сделка
+			name:    "consecutive losses per leg",
+			cfg:     RiskConfig{MaxConsecutiveLosses: 2},
+			results: []float64{-5, -3},
+			steps: []riskStep{
+				{at: day, balance: 1000, legs: []TradingPosition{LONG, SHORT}},
+				{at: day.Add(time.Hour), balance: 1010, legs: []TradingPosition{SHORT}},
+				{at: day.Add(2 * time.Hour), balance: 1020},
+			},
+			wantHalted: "2 consecutive losses",
+			wantLosses: 2,
+			wantStart:  1000,
+		},
+		{
+			name:    "profit resets losses",
+			cfg:     RiskConfig{MaxConsecutiveLosses: 2},
+			results: []float64{-5, 2, -1},
+			steps: []riskStep{
+				{at: day, balance: 1000, legs: []TradingPosition{LONG}},
+				{at: day.Add(time.Hour), balance: 1000},
+				{at: day.Add(2 * time.Hour), balance: 1000, legs: []TradingPosition{SHORT}},
+				{at: day.Add(3 * time.Hour), balance: 1000},
+				{at: day.Add(4 * time.Hour), balance: 1000, legs: []TradingPosition{LONG}},
+				{at: day.Add(5 * time.Hour), balance: 1000},
+			},
+			wantLosses: 1,
+			wantStart:  1000,
+		},
+		{
+			name: "halt kept on new session",
+			cfg:  RiskConfig{MaxDailyLoss: 50},
+			steps: []riskStep{
+				{at: day.Add(10 * time.Hour), balance: 1000},
+				{at: day.Add(11 * time.Hour), balance: 940},
+				{at: day.Add(25 * time.Hour), balance: 940},
+			},
+			wantHalted: "daily loss 60.00",
+			wantStart:  940,
+		},
+		{
+			name:    "reset on new session",
+			cfg:     RiskConfig{MaxDailyLoss: 50, MaxConsecutiveLosses: 3, ResetOnNewSession: true},
+			results: []float64{-60},
+			steps: []riskStep{
+				{at: day.Add(10 * time.Hour), balance: 1000, legs: []TradingPosition{LONG}},
+				{at: day.Add(11 * time.Hour), balance: 940},
+				{at: day.Add(25 * time.Hour), balance: 940},
+			},
+			wantStart: 940,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rm := &RiskManager{cfg: tt.cfg}
+			results := tt.results
+			result := func(side TradingPosition, from time.Time) (float64, error) {
+				if len(results) == 0 {
+					t.Fatalf("unexpected trade result of %s", side)
+				}
+				pnl := results[0]
+				results = results[1:]
+				return pnl, nil
+			}
+
+			for _, st := range tt.steps {
+				if _, err := rm.Update(st.position(), st.at, result); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s := rm.State()
+			if !strings.HasPrefix(s.HaltReason, tt.wantHalted) || s.Halted != (tt.wantHalted != "") {
+				t.Errorf("halted %t (%s), want %q", s.Halted, s.HaltReason, tt.wantHalted)
+			}
+			if s.ConsecutiveLosses != tt.wantLosses {
+				t.Errorf("ConsecutiveLosses = %d, want %d", s.ConsecutiveLosses, tt.wantLosses)
+			}
+			if s.SessionStartBalance != tt.wantStart {
+				t.Errorf("SessionStartBalance = %f, want %f", s.SessionStartBalance, tt.wantStart)
+			}
+			if len(results) > 0 {
+				t.Errorf("trade results not requested: %v", results)
+			}
+		})
+	}
+}
+
+func TestRiskManagerTradeResultRetry(t *testing.T) {
+	day := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	rm := &RiskManager{cfg: RiskConfig{MaxConsecutiveLosses: 1}}
+	opened := riskStep{at: day, balance: 1000, legs: []TradingPosition{LONG}}
+	closed := riskStep{at: day.Add(time.Hour), balance: 990}
+
+	var from []time.Time
+	fail := errors.New("exchange error")
+	result := func(side TradingPosition, f time.Time) (float64, error) {
+		from = append(from, f)
+		return -10, fail
+	}
+
+	if _, err := rm.Update(opened.position(), opened.at, result); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rm.Update(closed.position(), closed.at, result); !errors.Is(err, fail) {
+		t.Fatalf("Update error = %v, want %v", err, fail)
+	}
+	// без результата сторона остается открытой и запрашивается снова
+	fail = nil
+	closed.at = closed.at.Add(time.Hour)
+	halted, err := rm.Update(closed.position(), closed.at, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !halted || len(from) != 2 || !from[1].Equal(day) {
+		t.Errorf("halted %t, results requested from %v", halted, from)
+	}
+}
+
+func TestRiskManagerReset(t *testing.T) {
+	day := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	rm := &RiskManager{cfg: RiskConfig{MaxDrawdownPercent: 0.1}}
+	noTrades := func(TradingPosition, time.Time) (float64, error) { return 0, nil }
+
+	for _, st := range []riskStep{{at: day, balance: 1000}, {at: day.Add(time.Hour), balance: 850}} {
+		if _, err := rm.Update(st.position(), st.at, noTrades); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !rm.State().Halted {
+		t.Fatal("drawdown did not halt trading")
+	}
+
+	if err := rm.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	s := rm.State()
+	if s.Halted || s.PeakEquity != 0 || s.Session != "" || s.ConsecutiveLosses != 0 {
+		t.Fatalf("state after reset: %+v", s)
+	}
+
+	// просадка считается от капитала после сброса
+	halted, err := rm.Update((&riskStep{balance: 850}).position(), day.Add(2*time.Hour), noTrades)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s = rm.State(); halted || s.PeakEquity != 850 || s.SessionStartBalance != 850 {
+		t.Errorf("halted %t, peak %f, session start %f after reset", halted, s.PeakEquity, s.SessionStartBalance)
+	}
+}
+
+func TestBinanceTradeResult(t *testing.T) {
+	from := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 10)
+
+	trade := func(id int64, at time.Time, ps futures.PositionSideType, pnl, commission, asset string) *futures.AccountTrade {
+		return &futures.AccountTrade{ID: id, Time: at.UnixMilli(), PositionSide: ps, RealizedPnl: pnl, Commission: commission, CommissionAsset: asset}
+	}
+	var trades []*futures.AccountTrade
+	// больше страницы сделок с одним временем и частью на следующей странице
+	for i := 0; i < accountTradesLimit+200; i++ {
+		trades = append(trades, trade(int64(i+1), from.Add(time.Duration(i/300)*time.Second), futures.PositionSideTypeLong, "1", "0.1", "USDT"))
+	}
+	trades = append(trades,
+		trade(2001, from.Add(time.Hour), futures.PositionSideTypeShort, "-100", "0.1", "USDT"),
+		trade(2002, from.Add(2*time.Hour), futures.PositionSideTypeLong, "5", "0.01", "BNB"),
+		// в следующем окне 7 дней
+		trade(2003, from.AddDate(0, 0, 8), futures.PositionSideTypeLong, "-20", "0.5", "USDT"),
+	)
+
+	bc := testExchange(t, func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.ParseInt(r.URL.Query().Get("startTime"), 10, 64)
+		end, _ := strconv.ParseInt(r.URL.Query().Get("endTime"), 10, 64)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if end-start >= accountTradesWindow.Milliseconds() {
+			writeAPIError(w, -4166)
+			return
+		}
+		var page []*futures.AccountTrade
+		for _, tr := range trades {
+			if tr.Time >= start && tr.Time <= end && len(page) < limit {
+				page = append(page, tr)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	})
+
+	tests := []struct {
+		side  TradingPosition
+		hedge bool
+		want  float64
+	}{
+		{LONG, true, float64(accountTradesLimit+200)*0.9 + 5 - 20.5},
+		{SHORT, true, -100.1},
+		// в одностороннем режиме у сделок сторона BOTH
+		{LONG, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.side)+" hedge "+strconv.FormatBool(tt.hedge), func(t *testing.T) {
+			got, err := binanceTradeResult(context.Background(), bc, "ETHUSDT", tt.side, tt.hedge, from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := got - tt.want; diff > 1e-6 || diff < -1e-6 {
+				t.Errorf("binanceTradeResult = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// weekOffset смещение начала недельных свечей (понедельник) от начала
+// эпохи Unix (четверг).
+const weekOffset = 4 * 24 * time.Hour
+
+// ScheduleConfig расписание торговых шагов.
+type ScheduleConfig struct {
+	// SignalDelay задержка после закрытия свечи перед оценкой сигнала,
+	// чтобы биржа успела отдать закрытую свечу.
+	SignalDelay time.Duration `mapstructure:"signalDelay"`
+	// ManageInterval период управления открытой позицией между
+	// закрытиями свечей, 0 - только при закрытии свечи.
+	ManageInterval time.Duration `mapstructure:"manageInterval"`
+}
+
+// Tick шаг торговли.
+type Tick struct {
+	// At время шага.
+	At time.Time
+	// CandleClose шаг после закрытия свечи: оценивается сигнал на вход.
+	CandleClose bool
+	// Close время закрытия свечи для шага CandleClose.
+	Close time.Time
+	// Missed кол-во закрытий свечей, пропущенных с предыдущего шага CandleClose.
+	Missed int
+}
+
+// Scheduler планирует шаги торговли: через SignalDelay после закрытия
+// каждой свечи интервала и с периодом ManageInterval между ними.
+type Scheduler struct {
+	interval string
+	step     time.Duration
+	cfg      ScheduleConfig
+
+	lastClose  time.Time
+	lastManage time.Time
+}
+
+// NewScheduler создает расписание для интервала свечей interval.
+func NewScheduler(interval string, cfg ScheduleConfig) (*Scheduler, error) {
+	step, err := intervalDuration(interval)
+	if err != nil {
+		return nil, err
+	}
+	return &Scheduler{interval: interval, step: step, cfg: cfg}, nil
+}
+
+// Next возвращает ближайший после now шаг.
+func (s *Scheduler) Next(now time.Time) Tick {
+	closeAt := s.closeAfter(now.Add(-s.cfg.SignalDelay))
+	candle := Tick{At: closeAt.Add(s.cfg.SignalDelay), CandleClose: true, Close: closeAt}
+
+	if s.cfg.ManageInterval > 0 {
+		// первый и просроченный шаг управления выполняются сразу
+		manageAt := now
+		if next := s.lastManage.Add(s.cfg.ManageInterval); !s.lastManage.IsZero() && next.After(now) {
+			manageAt = next
+		}
+		if manageAt.Before(candle.At) {
+			return Tick{At: manageAt}
+		}
+	}
+
+	return candle
+}
+
+// Done отмечает выполнение шага и считает пропущенные закрытия свечей.
+func (s *Scheduler) Done(tick *Tick) {
+	s.lastManage = tick.At
+	if !tick.CandleClose {
+		return
+	}
+
+	if !s.lastClose.IsZero() {
+		for c := s.closeAfter(s.lastClose); c.Before(tick.Close); c = s.closeAfter(c) {
+			tick.Missed++
+		}
+	}
+	s.lastClose = tick.Close
+}
+
+// closeAfter время первого закрытия свечи строго после t. Свечи биржи
+// выровнены по началу эпохи Unix, недельные - по понедельнику,
+// месячные - по началу месяца.
+func (s *Scheduler) closeAfter(t time.Time) time.Time {
+	t = t.UTC()
+
+	if strings.HasSuffix(s.interval, "M") {
+		months := int(s.step / (30 * 24 * time.Hour))
+		next := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		for !next.After(t) {
+			next = next.AddDate(0, months, 0)
+		}
+		return next
+	}
+
+	var offset time.Duration
+	if strings.HasSuffix(s.interval, "w") {
+		offset = weekOffset
+	}
+	since := t.Sub(time.Unix(0, 0).Add(offset))
+	return time.Unix(0, 0).Add(offset + (since/s.step+1)*s.step).UTC()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func mustScheduler(t *testing.T, interval string, cfg ScheduleConfig) *Scheduler {
+	t.Helper()
+	s, err := NewScheduler(interval, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSchedulerCloseAfter(t *testing.T) {
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.DateTime, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		interval string
+		at       string
+		want     string
+	}{
+		{"1m", "2024-03-05 10:15:30", "2024-03-05 10:16:00"},
+		{"15m", "2024-03-05 10:15:00", "2024-03-05 10:30:00"},
+		{"1h", "2024-03-05 10:59:59", "2024-03-05 11:00:00"},
+		{"4h", "2024-03-05 10:00:00", "2024-03-05 12:00:00"},
+		{"1d", "2024-03-05 23:00:00", "2024-03-06 00:00:00"},
+		// 2024-03-04 - понедельник
+		{"1w", "2024-03-06 12:00:00", "2024-03-11 00:00:00"},
+		{"1w", "2024-03-04 00:00:00", "2024-03-11 00:00:00"},
+		{"1M", "2024-01-31 12:00:00", "2024-02-01 00:00:00"},
+		{"1M", "2024-02-01 00:00:00", "2024-03-01 00:00:00"},
+	}
+	for _, tt := range tests {
+		s := mustScheduler(t, tt.interval, ScheduleConfig{})
+		if got := s.closeAfter(utc(tt.at)); !got.Equal(utc(tt.want)) {
+			t.Errorf("%s closeAfter(%s) = %s, want %s", tt.interval, tt.at, got.Format(time.DateTime), tt.want)
+		}
+	}
+}
+
+func TestSchedulerNext(t *testing.T) {
+	base := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		cfg        ScheduleConfig
+		lastManage time.Time
+		now        time.Time
+		wantAt     time.Time
+		wantCandle bool
+	}{
+		{
+			name:       "candle close with delay",
+			cfg:        ScheduleConfig{SignalDelay: 2 * time.Second},
+			now:        base.Add(10 * time.Minute),
+			wantAt:     base.Add(time.Hour + 2*time.Second),
+			wantCandle: true,
+		},
+		{
+			name:       "within delay after close",
+			cfg:        ScheduleConfig{SignalDelay: 2 * time.Second},
+			now:        base.Add(time.Second),
+			wantAt:     base.Add(2 * time.Second),
+			wantCandle: true,
+		},
+		{
+			name:   "first manage tick runs now",
+			cfg:    ScheduleConfig{ManageInterval: time.Minute},
+			now:    base.Add(10 * time.Minute),
+			wantAt: base.Add(10 * time.Minute),
+		},
+		{
+			name:       "manage tick after interval",
+			cfg:        ScheduleConfig{ManageInterval: time.Minute},
+			lastManage: base.Add(10 * time.Minute),
+			now:        base.Add(10*time.Minute + 20*time.Second),
+			wantAt:     base.Add(11 * time.Minute),
+		},
+		{
+			name:       "candle close before next manage tick",
+			cfg:        ScheduleConfig{ManageInterval: 10 * time.Minute},
+			lastManage: base.Add(55 * time.Minute),
+			now:        base.Add(56 * time.Minute),
+			wantAt:     base.Add(time.Hour),
+			wantCandle: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := mustScheduler(t, "1h", tt.cfg)
+			s.lastManage = tt.lastManage
+			tick := s.Next(tt.now)
+			if !tick.At.Equal(tt.wantAt) || tick.CandleClose != tt.wantCandle {
+				t.Errorf("Next = %s candle %t, want %s candle %t",
+					tick.At.Format(time.DateTime), tick.CandleClose, tt.wantAt.Format(time.DateTime), tt.wantCandle)
+			}
+		})
+	}
+}
+
+func TestSchedulerMissed(t *testing.T) {
+	base := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		closes []time.Time
+		want   int
+	}{
+		{"first tick", []time.Time{base}, 0},
+		{"consecutive", []time.Time{base, base.Add(time.Hour)}, 0},
+		{"two skipped", []time.Time{base, base.Add(3 * time.Hour)}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := mustScheduler(t, "1h", ScheduleConfig{})
+			var tick Tick
+			for _, c := range tt.closes {
+				tick = Tick{At: c, CandleClose: true, Close: c}
+				s.Done(&tick)
+			}
+			if tick.Missed != tt.want {
+				t.Errorf("Missed = %d, want %d", tick.Missed, tt.want)
+			}
+		})
+	}
+}
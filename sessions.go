@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// SessionsConfig торговые сессии: в какие дни и часы разрешено
+// открывать новые позиции.
+type SessionsConfig struct {
+	// Timezone часовой пояс окон и календаря, например Europe/Moscow.
+	Timezone string `mapstructure:"timezone"`
+	// Windows окна торговли, пустой список разрешает торговлю всегда.
+	Windows []TradingWindow `mapstructure:"windows"`
+	// MaxRunDuration максимальная длительность работы бота, 0 - без ограничения.
+	MaxRunDuration time.Duration `mapstructure:"maxRunDuration"`
+	// BlackoutFile csv-файл с периодами запрета входа (from,to,reason).
+	BlackoutFile string `mapstructure:"blackoutFile"`
+}
+
+// TradingWindow окно торговли. Если From позже To, окно переходит
+// через полночь и относится к дню своего начала.
+type TradingWindow struct {
+	// Days дни недели: mon, tue, wed, thu, fri, sat, sun. Пустой список - все дни.
+	Days []string `mapstructure:"days"`
+	// From начало окна, HH:MM.
+	From string `mapstructure:"from"`
+	// To конец окна, HH:MM.
+	To string `mapstructure:"to"`
+}
+
+// Blackout период запрета открытия позиций.
+type Blackout struct {
+	From   time.Time
+	To     time.Time
+	Reason string
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// tradingWindow разобранное окно торговли, время в минутах от полуночи.
+type tradingWindow struct {
+	days     map[time.Weekday]bool
+	from, to int
+}
+
+func (w tradingWindow) hasDay(d time.Weekday) bool {
+	return len(w.days) == 0 || w.days[d]
+}
+
+// contains проверяет, попадает ли время t в окно.
+func (w tradingWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.from <= w.to {
+		return w.hasDay(t.Weekday()) && m >= w.from && m < w.to
+	}
+	// окно через полночь: вечер дня начала или утро следующего дня
+	return (w.hasDay(t.Weekday()) && m >= w.from) || (w.hasDay(t.AddDate(0, 0, -1).Weekday()) && m < w.to)
+}
+
+// TradingCalendar проверяет, разрешено ли открывать позиции в заданное время.
+type TradingCalendar struct {
+	loc       *time.Location
+	windows   []tradingWindow
+	blackouts []Blackout
+}
+
+// LoadTradingCalendar разбирает окна торговли и загружает календарь запретов.
+func LoadTradingCalendar(cfg SessionsConfig) (*TradingCalendar, error) {
+	loc, windows, err := parseTradingWindows(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &TradingCalendar{loc: loc, windows: windows}
+	if cfg.BlackoutFile != "" {
+		if tc.blackouts, err = loadBlackouts(cfg.BlackoutFile, loc); err != nil {
+			return nil, err
+		}
+	}
+
+	return tc, nil
+}
+
+// EntryAllowed сообщает, можно ли открыть позицию в момент t,
+// и если нельзя - почему.
+func (tc *TradingCalendar) EntryAllowed(t time.Time) (bool, string) {
+	t = t.In(tc.loc)
+
+	for _, b := range tc.blackouts {
+		if !t.Before(b.From) && t.Before(b.To) {
+			return false, fmt.Sprintf("период запрета до %s: %s", b.To.Format("02.01 15:04"), b.Reason)
+		}
+	}
+
+	if len(tc.windows) == 0 {
+		return true, ""
+	}
+	for _, w := range tc.windows {
+		if w.contains(t) {
+			return true, ""
+		}
+	}
+
+	return false, "вне окна торговли"
+}
+
+// parseTradingWindows разбирает часовой пояс и окна торговли.
+func parseTradingWindows(cfg SessionsConfig) (*time.Location, []tradingWindow, error) {
+	loc := time.UTC
+	if cfg.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, nil, fmt.Errorf("invalid sessions.timezone: %w", err)
+		}
+	}
+
+	var windows []tradingWindow
+	for i, w := range cfg.Windows {
+		tw := tradingWindow{days: make(map[time.Weekday]bool)}
+		for _, d := range w.Days {
+			wd, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return nil, nil, fmt.Errorf("sessions.windows[%d]: unknown day %q", i, d)
+			}
+			tw.days[wd] = true
+		}
+
+		var err error
+		if tw.from, err = parseClock(w.From); err != nil {
+			return nil, nil, fmt.Errorf("sessions.windows[%d].from: %w", i, err)
+		}
+		if tw.to, err = parseClock(w.To); err != nil {
+			return nil, nil, fmt.Errorf("sessions.windows[%d].to: %w", i, err)
+		}
+		if tw.from == tw.to {
+			return nil, nil, fmt.Errorf("sessions.windows[%d]: from and to must differ", i)
+		}
+		windows = append(windows, tw)
+	}
+
+	return loc, windows, nil
+}
+
+// parseClock разбирает время HH:MM в минуты от полуночи.
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// loadBlackouts загружает периоды запрета из csv-файла с колонками
+// from,to,reason. Время в формате RFC 3339 или "YYYY-MM-DD HH:MM"
+// в часовом поясе loc.
+func loadBlackouts(path string, loc *time.Location) ([]Blackout, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	if _, err = reader.Read(); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var blackouts []Blackout
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("%s: invalid blackout record %v", path, record)
+		}
+
+		from, errFrom := parseCalendarTime(record[0], loc)
+		to, errTo := parseCalendarTime(record[1], loc)
+		if err = errors.Join(errFrom, errTo); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if !to.After(from) {
+			return nil, fmt.Errorf("%s: blackout %s ends before it starts", path, record[0])
+		}
+
+		b := Blackout{From: from, To: to}
+		if len(record) > 2 {
+			b.Reason = strings.TrimSpace(record[2])
+		}
+		blackouts = append(blackouts, b)
+	}
+
+	return blackouts, nil
+}
+
+func parseCalendarTime(value string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return t, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTradingCalendarWindows(t *testing.T) {
+	tc, err := LoadTradingCalendar(SessionsConfig{
+		Timezone: "Europe/Moscow",
+		Windows: []TradingWindow{
+			{Days: []string{"mon", "tue", "wed", "thu", "fri"}, From: "10:00", To: "18:00"},
+			// через полночь, относится к пятнице
+			{Days: []string{"fri"}, From: "22:00", To: "02:00"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msk := tc.loc
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"weekday inside", time.Date(2024, 3, 5, 12, 0, 0, 0, msk), true},
+		{"window start inclusive", time.Date(2024, 3, 5, 10, 0, 0, 0, msk), true},
+		{"window end exclusive", time.Date(2024, 3, 5, 18, 0, 0, 0, msk), false},
+		{"weekday before", time.Date(2024, 3, 5, 9, 59, 0, 0, msk), false},
+		{"saturday", time.Date(2024, 3, 9, 12, 0, 0, 0, msk), false},
+		{"friday night", time.Date(2024, 3, 8, 23, 0, 0, 0, msk), true},
+		{"after midnight from friday", time.Date(2024, 3, 9, 1, 30, 0, 0, msk), true},
+		{"after midnight from thursday", time.Date(2024, 3, 8, 1, 30, 0, 0, msk), false},
+		// 09:00 UTC - 12:00 по Москве
+		{"utc converted to timezone", time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC), true},
+		{"utc outside after conversion", time.Date(2024, 3, 5, 16, 0, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, reason := tc.EntryAllowed(tt.at); got != tt.want {
+				t.Errorf("EntryAllowed(%s) = %t (%s), want %t", tt.at, got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestTradingCalendarBlackouts(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "blackout.csv")
+	data := "from,to,reason\n" +
+		"2024-03-05 14:00,2024-03-05 15:00,CPI\n" +
+		"2024-03-06T12:00:00Z,2024-03-06T13:00:00Z,FOMC\n"
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tc, err := LoadTradingCalendar(SessionsConfig{Timezone: "Europe/Moscow", BlackoutFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msk := tc.loc
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"before local blackout", time.Date(2024, 3, 5, 13, 59, 0, 0, msk), true},
+		{"local blackout start", time.Date(2024, 3, 5, 14, 0, 0, 0, msk), false},
+		{"local blackout end", time.Date(2024, 3, 5, 15, 0, 0, 0, msk), true},
+		{"utc blackout", time.Date(2024, 3, 6, 15, 30, 0, 0, msk), false},
+		{"after utc blackout", time.Date(2024, 3, 6, 13, 0, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, reason := tc.EntryAllowed(tt.at); got != tt.want {
+				t.Errorf("EntryAllowed(%s) = %t (%s), want %t", tt.at, got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTradingWindowsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  SessionsConfig
+	}{
+		{"unknown timezone", SessionsConfig{Timezone: "Mars/Olympus"}},
+		{"unknown day", SessionsConfig{Windows: []TradingWindow{{Days: []string{"xyz"}, From: "10:00", To: "11:00"}}}},
+		{"invalid time", SessionsConfig{Windows: []TradingWindow{{From: "25:00", To: "11:00"}}}},
+		{"empty window", SessionsConfig{Windows: []TradingWindow{{From: "10:00", To: "10:00"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := parseTradingWindows(tt.cfg); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// jsonFloat число, которое записывается в JSON как null, если оно
+// не определено (NaN или бесконечность).
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return []byte("null"), nil
+	}
+	return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+}
+
+func (f *jsonFloat) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*f = jsonFloat(math.NaN())
+		return nil
+	}
+	v, err := strconv.ParseFloat(string(b), 64)
+	*f = jsonFloat(v)
+	return err
+}
+
+// SignalRecord запись журнала оценки сигнала: показатели свечи, пороги
+// стратегии и результат каждого проверенного условия.
+type SignalRecord struct {
+	EvaluatedAt        time.Time         `json:"evaluatedAt"`
+	Symbol             string            `json:"symbol"`
+	Interval           string            `json:"interval"`
+	CandleTime         time.Time         `json:"candleTime"`
+	LocalMin           bool              `json:"localMin"`
+	LocalMax           bool              `json:"localMax"`
+	PosInChan          jsonFloat         `json:"posInChan"`
+	Slope              jsonFloat         `json:"slope"`
+	PosInChanThreshold float64           `json:"posInChanThreshold"`
+	SlopeThreshold     float64           `json:"slopeThreshold"`
+	Conditions         []SignalCondition `json:"conditions"`
+	Signal             TradingPosition   `json:"signal"`
+	VetoedBy           string            `json:"vetoedBy,omitempty"`
+	Reason             string            `json:"reason"`
+	// Error ошибка, прервавшая оценку.
+	Error string `json:"error,omitempty"`
+}
+
+// newSignalRecord составляет запись журнала по результату оценки d.
+func newSignalRecord(d SignalDecision, cfg *Config, evaluatedAt time.Time) SignalRecord {
+	p := cfg.StrategyParams()
+	return SignalRecord{
+		EvaluatedAt:        evaluatedAt,
+		Symbol:             cfg.Symbol,
+		Interval:           cfg.Interval,
+		CandleTime:         d.CandleTime,
+		LocalMin:           d.LocalMin,
+		LocalMax:           d.LocalMax,
+		PosInChan:          jsonFloat(d.PosInChan),
+		Slope:              jsonFloat(d.Slope),
+		PosInChanThreshold: p.PosInChanThreshold,
+		SlopeThreshold:     p.SlopeThreshold,
+		Conditions:         d.Conditions,
+		Signal:             d.Signal,
+		VetoedBy:           d.VetoedBy,
+		Reason:             d.Reason,
+	}
+}
+
+// appendSignalRecord дописывает запись в JSONL-файл file.
+func appendSignalRecord(file string, rec SignalRecord) error {
+	return appendJSONL(file, rec)
+}
+
+// readSignalRecords читает последние n записей журнала file.
+func readSignalRecords(file string, n int) ([]SignalRecord, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []SignalRecord
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var rec SignalRecord
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		records = append(records, rec)
+		if len(records) > n {
+			records = records[1:]
+		}
+	}
+
+	return records, scanner.Err()
+}
+
+// printSignalRecord выводит обоснование оценки сигнала.
+func printSignalRecord(rec SignalRecord) {
+	fmt.Printf("Оценка %s, свеча %s %s %s\n", rec.EvaluatedAt.Local().Format(time.DateTime),
+		rec.Symbol, rec.Interval, rec.CandleTime.Local().Format(time.DateTime))
+	fmt.Printf("  Локальный минимум: %t, локальный максимум: %t\n", rec.LocalMin, rec.LocalMax)
+	fmt.Printf("  Позиция в канале: %.3f (порог %g), наклон: %.2f (порог %g)\n",
+		rec.PosInChan, rec.PosInChanThreshold, rec.Slope, rec.SlopeThreshold)
+	for _, c := range rec.Conditions {
+		mark := "нет"
+		if c.Passed {
+			mark = "да"
+		}
+		fmt.Printf("  %-24s значение %.4f, порог %.4f: %s\n", c.Name, c.Value, c.Threshold, mark)
+	}
+	signal := string(rec.Signal)
+	if signal == "" {
+		signal = "нет"
+	}
+	fmt.Printf("  Сигнал: %s, причина: %s\n", signal, rec.Reason)
+	if rec.Error != "" {
+		fmt.Printf("  Ошибка: %s\n", rec.Error)
+	}
+}
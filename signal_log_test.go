@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONFloat(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    float64
+		wantJSON string
+	}{
+		{"number", 1.5, "1.5"},
+		{"negative", -0.25, "-0.25"},
+		{"zero", 0, "0"},
+		// неопределенные значения записываются как null и читаются как NaN
+		{"nan", math.NaN(), "null"},
+		{"inf", math.Inf(1), "null"},
+		{"negative inf", math.Inf(-1), "null"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(SignalCondition{Value: jsonFloat(tt.value)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := `"value":` + tt.wantJSON + `,`; !strings.Contains(string(b), want) {
+				t.Fatalf("Marshal = %s, want %s", b, want)
+			}
+
+			var c SignalCondition
+			if err = json.Unmarshal(b, &c); err != nil {
+				t.Fatal(err)
+			}
+			got := float64(c.Value)
+			if tt.wantJSON == "null" {
+				if !math.IsNaN(got) {
+					t.Errorf("round trip of %f = %f, want NaN", tt.value, got)
+				}
+			} else if got != tt.value {
+				t.Errorf("round trip of %f = %f", tt.value, got)
+			}
+		})
+	}
+}
+
+func TestReadSignalRecords(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "signals.jsonl")
+	reasons := []string{"r1", "r2", "r3", "r4", "r5"}
+	for _, r := range reasons {
+		if err := appendSignalRecord(file, SignalRecord{Reason: r, Slope: jsonFloat(math.NaN()), PosInChan: 0.5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		n    int
+		want []string
+	}{
+		{"last records", 3, reasons[2:]},
+		{"fewer than n", 10, reasons},
+		{"last record", 1, reasons[4:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := readSignalRecords(file, tt.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, rec := range records {
+				got = append(got, rec.Reason)
+				if !math.IsNaN(float64(rec.Slope)) || rec.PosInChan != 0.5 {
+					t.Errorf("record %s: slope %f, position in channel %f", rec.Reason, rec.Slope, rec.PosInChan)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("readSignalRecords(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSignalRecordsMalformed(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "signals.jsonl")
+	data := `{"reason":"r1"}` + "\n" + `{"reason":"r2"}` + "\n" + `{"reason":` + "\n" + `{"reason":"r4"}` + "\n"
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// ошибка указывает файл и строку
+	_, err := readSignalRecords(file, 10)
+	if err == nil || !strings.HasPrefix(err.Error(), file+":3:") {
+		t.Errorf("readSignalRecords error = %v, want error at %s:3", err, file)
+	}
+
+	if _, err = readSignalRecords(filepath.Join(t.TempDir(), "missing.jsonl"), 10); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("readSignalRecords of missing file error = %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/adshao/go-binance/v2/futures"
+	"os"
+	"path/filepath"
+)
+
+// LegState состояние сопровождения одной стороны позиции: уровень
+// стоп-лосса и оставшиеся уровни фиксации прибыли.
+type LegState struct {
+	// PositionID ID позиции в ID ордеров бота.
+	PositionID string  `json:"positionId"`
+	EntryPrice float64 `json:"entryPrice"`
+	StopPrice  float64 `json:"stopPrice"`
+	// StopOrderID ID стоп-лосса на бирже, 0 - стоп-лосс не выставлен.
+	StopOrderID int64 `json:"stopOrderId"`
+	// StopMoved уровень стоп-лосса изменился, стоп-лосс на бирже нужно
+	// перевыставить.
+	StopMoved bool `json:"stopMoved"`
+	// EntryAmount объем входа, от которого считаются объемы фиксации прибыли.
+	EntryAmount float64 `json:"entryAmount"`
+	Ladder      [][]int `json:"ladder"`
+	// PendingTakeProfit уровень фиксации прибыли, ордер которого отправлен,
+	// но еще не завершен. Уровень снимается с Ladder после исполнения.
+	PendingTakeProfit int `json:"pendingTakeProfit"`
+	// NearLiquidation расстояние до ликвидации меньше допустимого,
+	// предупреждение уже отправлено.
+	NearLiquidation bool `json:"nearLiquidation"`
+	// Manual сторона открыта не ботом: стоп-лосс и фиксация прибыли
+	// для нее не выставляются.
+	Manual bool `json:"manual"`
+}
+
+// TradeState состояние сопровождения позиций между шагами торговли,
+// отдельно для LONG и SHORT.
+type TradeState struct {
+	file   string
+	ladder [][]int
+	legs   map[TradingPosition]*LegState
+	// entries ID позиций, вход в которые отправлен, но еще не получен от биржи.
+	entries map[TradingPosition]string
+	// highMarginRatio уровень маржи выше допустимого, предупреждение уже отправлено.
+	highMarginRatio bool
+}
+
+// NewTradeState создает состояние с уровнями фиксации прибыли ladder
+// для каждой новой стороны позиции.
+func NewTradeState(ladder [][]int) *TradeState {
+	return &TradeState{
+		ladder:  ladder,
+		legs:    make(map[TradingPosition]*LegState),
+		entries: make(map[TradingPosition]string),
+	}
+}
+
+// savedTradeState состояние сопровождения позиций в файле.
+type savedTradeState struct {
+	Legs    map[TradingPosition]*LegState `json:"legs"`
+	Entries map[TradingPosition]string    `json:"entries,omitempty"`
+}
+
+// LoadTradeState создает состояние и загружает сохраненное в file
+// в прошлом запуске. Пустой file - состояние не сохраняется.
+func LoadTradeState(file string, ladder [][]int) (*TradeState, error) {
+	s := NewTradeState(ladder)
+	s.file = file
+	if file == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved savedTradeState
+	if err = json.Unmarshal(b, &saved); err != nil {
+		return nil, fmt.Errorf("read trade state %s: %w", file, err)
+	}
+	for side, ls := range saved.Legs {
+		s.legs[side] = ls
+	}
+	for side, id := range saved.Entries {
+		s.entries[side] = id
+	}
+
+	return s, nil
+}
+
+// Save сохраняет состояние в файл.
+func (s *TradeState) Save() error {
+	if s.file == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0o755); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(savedTradeState{Legs: s.legs, Entries: s.entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.file, b, 0o644)
+}
+
+// Sync приводит состояние в соответствие с открытыми сторонами позиции:
+// для новой стороны восстанавливает все уровни фиксации прибыли, для
+// закрытой удаляет состояние. Стоп-лосс пересчитывается при изменении
+// цены входа.
+func (s *TradeState) Sync(pos *OpenedPosition, stopPercent float64) {
+	for side := range s.legs {
+		if pos.Leg(side) == nil {
+			delete(s.legs, side)
+		}
+	}
+
+	for _, leg := range pos.Legs {
+		ls, ok := s.legs[leg.Side]
+		if !ok {
+			ls = &LegState{Ladder: append([][]int(nil), s.ladder...)}
+			ls.PositionID = s.entries[leg.Side]
+			if ls.PositionID == "" {
+				ls.PositionID = fallbackPositionID(leg)
+			}
+			delete(s.entries, leg.Side)
+			s.legs[leg.Side] = ls
+		}
+		// объем входа растет, пока вход исполняется частями
+		if ls.EntryAmount == 0 || (s.TakeProfitLevel(ls) == 1 && ls.PendingTakeProfit == 0 && leg.Amount > ls.EntryAmount) {
+			ls.EntryAmount = leg.Amount
+		}
+		if ls.EntryPrice != leg.EntryPrice {
+			ls.EntryPrice = leg.EntryPrice
+			ls.StopMoved = ls.StopOrderID != 0
+			if leg.Side == LONG {
+				ls.StopPrice = leg.EntryPrice * (1 - stopPercent)
+			} else {
+				ls.StopPrice = leg.EntryPrice * (1 + stopPercent)
+			}
+		}
+	}
+}
+
+// Enter запоминает ID позиции side, вход в которую отправлен на биржу.
+func (s *TradeState) Enter(side TradingPosition, positionID string) {
+	s.entries[side] = positionID
+}
+
+// PositionID возвращает ID позиции стороны leg. Для позиции без
+// состояния ID строится по цене входа.
+func (s *TradeState) PositionID(leg PositionLeg) string {
+	if s != nil {
+		if ls := s.legs[leg.Side]; ls != nil {
+			return ls.PositionID
+		}
+	}
+	return fallbackPositionID(leg)
+}
+
+// TakeProfitLevel номер следующего уровня фиксации прибыли, начиная с 1.
+func (s *TradeState) TakeProfitLevel(ls *LegState) int {
+	return len(s.ladder) - len(ls.Ladder) + 1
+}
+
+// Leg возвращает состояние стороны позиции side или nil.
+func (s *TradeState) Leg(side TradingPosition) *LegState {
+	return s.legs[side]
+}
+
+// ApplyOrderUpdates учитывает завершенные ордера бота из потока данных
+// пользователя: снятый или исполненный стоп-лосс на бирже будет выставлен
+// заново, исполненный уровень фиксации прибыли снимается с лестницы,
+// а снятый без исполнения будет повторен. Возвращает true, если завершен
+// хотя бы один ордер бота и позицию нужно сопровождать.
+func (s *TradeState) ApplyOrderUpdates(updates []OrderUpdate, cfg *Config) bool {
+	applied := false
+	for _, u := range updates {
+		positionID, action, ok := parseClientOrderID(cfg, u.ClientOrderID)
+		if !ok || !u.final() {
+			continue
+		}
+		applied = true
+
+		var side TradingPosition
+		var ls *LegState
+		for sd, l := range s.legs {
+			if l.PositionID == positionID {
+				side, ls = sd, l
+			}
+		}
+		if ls == nil {
+			continue
+		}
+
+		if action == ActionStopOrder && u.OrderID == ls.StopOrderID {
+			if u.Status == futures.OrderStatusTypeFilled {
+				fmt.Printf("Сработал стоп-лосс %s на бирже по %f\n", side, u.LastPrice)
+			} else {
+				fmt.Printf("Стоп-лосс %s снят с биржи (%s), будет выставлен заново\n", side, u.Status)
+			}
+			ls.StopOrderID = 0
+			continue
+		}
+
+		if level, ok := parseTakeProfitLevel(action); ok {
+			s.settleTakeProfit(side, ls, level, u.Status, u.Filled)
+		}
+	}
+	return applied
+}
+
+// settleTakeProfit учитывает завершение ордера фиксации прибыли уровня level
+// в статусе status с исполненным объемом filled: исполненный уровень
+// снимается с лестницы, не исполненный будет повторен.
+func (s *TradeState) settleTakeProfit(side TradingPosition, ls *LegState, level int, status futures.OrderStatusType, filled float64) {
+	if ls.PendingTakeProfit != level || !orderStatusFinal(status) {
+		return
+	}
+	ls.PendingTakeProfit = 0
+
+	if filled == 0 {
+		fmt.Printf("Ордер фиксации прибыли %s уровня %d не исполнен (%s), уровень будет повторен\n", side, level, status)
+		return
+	}
+	fmt.Printf("Зафиксирована прибыль %s на уровне %d: %f\n", side, level, filled)
+	// лестница могла быть восстановлена сверкой с биржей
+	if len(ls.Ladder) > 0 && s.TakeProfitLevel(ls) == level {
+		ls.Ladder = ls.Ladder[1:]
+	}
+}
+
+// HasLegs сообщает, сопровождается ли хотя бы одна сторона позиции.
+func (s *TradeState) HasLegs() bool {
+	return len(s.legs) > 0
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"github.com/adshao/go-binance/v2/futures"
+	"testing"
+)
+
+func TestTradeStateEntryAmount(t *testing.T) {
+	s := NewTradeState([][]int{{10, 3}, {20, 3}, {30, 4}})
+	sync := func(amount float64) *LegState {
+		s.Sync(&OpenedPosition{Legs: []PositionLeg{{Side: LONG, Amount: amount, EntryPrice: 100}}}, 0.01)
+		return s.Leg(LONG)
+	}
+
+	s.Enter(LONG, "l1")
+	if ls := sync(0.01); ls.EntryAmount != 0.01 {
+		t.Fatalf("EntryAmount = %f after first fill", ls.EntryAmount)
+	}
+	// вход исполняется частями
+	if ls := sync(0.03); ls.EntryAmount != 0.03 {
+		t.Fatalf("EntryAmount = %f after entry filled", ls.EntryAmount)
+	}
+
+	// после фиксации прибыли объем входа не меняется
+	ls := s.Leg(LONG)
+	ls.PendingTakeProfit = 1
+	s.settleTakeProfit(LONG, ls, 1, futures.OrderStatusTypeFilled, 0.009)
+	if len(ls.Ladder) != 2 || ls.PendingTakeProfit != 0 {
+		t.Fatalf("ladder %v, pending %d after take profit", ls.Ladder, ls.PendingTakeProfit)
+	}
+	if ls = sync(0.021); ls.EntryAmount != 0.03 {
+		t.Errorf("EntryAmount = %f after take profit", ls.EntryAmount)
+	}
+}
+
+func TestTradeStateSettleTakeProfit(t *testing.T) {
+	tests := []struct {
+		name        string
+		pending     int
+		level       int
+		status      futures.OrderStatusType
+		filled      float64
+		wantLadder  int
+		wantPending int
+	}{
+		{"filled", 1, 1, futures.OrderStatusTypeFilled, 0.01, 2, 0},
+		{"partially filled ioc", 1, 1, futures.OrderStatusTypeExpired, 0.005, 2, 0},
+		{"not filled is retried", 1, 1, futures.OrderStatusTypeCanceled, 0, 3, 0},
+		{"still open", 1, 1, futures.OrderStatusTypeNew, 0, 3, 1},
+		{"other level", 1, 2, futures.OrderStatusTypeFilled, 0.01, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewTradeState([][]int{{10, 3}, {20, 3}, {30, 4}})
+			s.Sync(&OpenedPosition{Legs: []PositionLeg{{Side: LONG, Amount: 0.03, EntryPrice: 100}}}, 0.01)
+			ls := s.Leg(LONG)
+			ls.PendingTakeProfit = tt.pending
+
+			s.settleTakeProfit(LONG, ls, tt.level, tt.status, tt.filled)
+			if len(ls.Ladder) != tt.wantLadder || ls.PendingTakeProfit != tt.wantPending {
+				t.Errorf("ladder %d, pending %d, want %d, %d", len(ls.Ladder), ls.PendingTakeProfit, tt.wantLadder, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestTradeStateApplyOrderUpdates(t *testing.T) {
+	cfg := &Config{BotID: "bot1", Symbol: "ETHUSDT"}
+	update := func(action string, orderID int64, status futures.OrderStatusType, filled float64) OrderUpdate {
+		return OrderUpdate{ClientOrderID: clientOrderID(cfg, "l1", action), OrderID: orderID, Status: status, Filled: filled, LastPrice: 99}
+	}
+
+	tests := []struct {
+		name    string
+		pending int
+		// taken уровни, уже снятые с лестницы
+		taken       int
+		updates     []OrderUpdate
+		wantApplied bool
+		wantLadder  int
+		wantPending int
+		wantStopID  int64
+	}{
+		{
+			name:        "filled stop",
+			updates:     []OrderUpdate{update(ActionStopOrder, 7, futures.OrderStatusTypeFilled, 0.03)},
+			wantApplied: true, wantLadder: 3,
+		},
+		{
+			// стоп-лосс, замененный новым, не сбрасывает текущий
+			name:        "replaced stop",
+			updates:     []OrderUpdate{update(ActionStopOrder, 6, futures.OrderStatusTypeCanceled, 0)},
+			wantApplied: true, wantLadder: 3, wantStopID: 7,
+		},
+		{
+			name:        "cancelled take profit is retried",
+			pending:     1,
+			updates:     []OrderUpdate{update(actionTakeProfit(1), 8, futures.OrderStatusTypeCanceled, 0)},
+			wantApplied: true, wantLadder: 3, wantStopID: 7,
+		},
+		{
+			name:       "partial fill waits for final status",
+			pending:    1,
+			updates:    []OrderUpdate{update(actionTakeProfit(1), 8, futures.OrderStatusTypePartiallyFilled, 0.005)},
+			wantLadder: 3, wantPending: 1, wantStopID: 7,
+		},
+		{
+			name:    "partial fill then filled",
+			pending: 1,
+			updates: []OrderUpdate{
+				update(actionTakeProfit(1), 8, futures.OrderStatusTypePartiallyFilled, 0.005),
+				update(actionTakeProfit(1), 8, futures.OrderStatusTypeFilled, 0.009),
+			},
+			wantApplied: true, wantLadder: 2, wantStopID: 7,
+		},
+		{
+			// сверка с биржей уже сняла уровень с лестницы
+			name:        "level taken by reconcile",
+			pending:     1,
+			taken:       1,
+			updates:     []OrderUpdate{update(actionTakeProfit(1), 8, futures.OrderStatusTypeFilled, 0.009)},
+			wantApplied: true, wantLadder: 2, wantStopID: 7,
+		},
+		{
+			// уровень уже не ожидается, повторное событие ничего не меняет
+			name:        "stale level",
+			updates:     []OrderUpdate{update(actionTakeProfit(1), 8, futures.OrderStatusTypeFilled, 0.009)},
+			wantApplied: true, wantLadder: 3, wantStopID: 7,
+		},
+		{
+			name:       "manual order",
+			updates:    []OrderUpdate{{ClientOrderID: "web_123", OrderID: 9, Status: futures.OrderStatusTypeFilled, Filled: 0.01}},
+			wantLadder: 3, wantStopID: 7,
+		},
+		{
+			name:        "closed position",
+			updates:     []OrderUpdate{{ClientOrderID: clientOrderID(cfg, "l0", ActionStopOrder), OrderID: 7, Status: futures.OrderStatusTypeFilled}},
+			wantApplied: true, wantLadder: 3, wantStopID: 7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewTradeState([][]int{{10, 3}, {20, 3}, {30, 4}})
+			s.Enter(LONG, "l1")
+			s.Sync(&OpenedPosition{Legs: []PositionLeg{{Side: LONG, Amount: 0.03, EntryPrice: 100}}}, 0.01)
+			ls := s.Leg(LONG)
+			ls.StopOrderID, ls.PendingTakeProfit = 7, tt.pending
+			ls.Ladder = ls.Ladder[tt.taken:]
+
+			if applied := s.ApplyOrderUpdates(tt.updates, cfg); applied != tt.wantApplied {
+				t.Errorf("ApplyOrderUpdates = %t, want %t", applied, tt.wantApplied)
+			}
+			if len(ls.Ladder) != tt.wantLadder || ls.PendingTakeProfit != tt.wantPending || ls.StopOrderID != tt.wantStopID {
+				t.Errorf("ladder %d, pending %d, stop order %d, want %d, %d, %d",
+					len(ls.Ladder), ls.PendingTakeProfit, ls.StopOrderID, tt.wantLadder, tt.wantPending, tt.wantStopID)
+			}
+		})
+	}
+}
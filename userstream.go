@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/adshao/go-binance/v2/futures"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// userStreamReconnectDelay пауза перед переподключением потока данных пользователя.
+const userStreamReconnectDelay = 5 * time.Second
+
+// OrderUpdate изменение ордера из потока данных пользователя.
+type OrderUpdate struct {
+	ClientOrderID string
+	OrderID       int64
+	Side          futures.SideType
+	PositionSide  futures.PositionSideType
+	Status        futures.OrderStatusType
+	// LastFilled кол-во, исполненное этим событием.
+	LastFilled float64
+	// Filled кол-во, исполненное с момента выставления ордера.
+	Filled      float64
+	LastPrice   float64
+	RealizedPnL float64
+	Commission  float64
+	Time        time.Time
+}
+
+// final сообщает, что ордер больше не изменится.
+func (u OrderUpdate) final() bool {
+	return orderStatusFinal(u.Status)
+}
+
+// orderStatusFinal сообщает, что ордер в статусе status больше не изменится.
+func orderStatusFinal(status futures.OrderStatusType) bool {
+	switch status {
+	case futures.OrderStatusTypeFilled, futures.OrderStatusTypeCanceled,
+		futures.OrderStatusTypeExpired, futures.OrderStatusTypeRejected:
+		return true
+	}
+	return false
+}
+
+// StreamPosition сторона позиции по данным потока.
+type StreamPosition struct {
+	Amount     float64
+	EntryPrice float64
+}
+
+// UserStream поток данных пользователя: исполнения ордеров и изменения
+// позиции приходят сразу, без опроса биржи. Поток хранит позицию по
+// валютной паре и копит изменения ордеров до обработки. Позиция из потока
+// только будит сопровождение: шаг торговли получает позицию опросом биржи,
+// опрос остается источником истины.
+type UserStream struct {
+	bc        *BinanceClient
+	symbol    string
+	keepalive time.Duration
+	notify    chan struct{}
+
+	mu        sync.Mutex
+	listenKey string
+	stop      chan struct{}
+	positions map[futures.PositionSideType]StreamPosition
+	updates   []OrderUpdate
+	// moved позиция изменилась с последнего TakeUpdates.
+	moved bool
+}
+
+// StartUserStream открывает поток данных пользователя и поддерживает его
+// до отмены ctx: продлевает ключ и переподключается при обрыве. Поток
+// работает в своей горутине через отдельный клиент биржи.
+func StartUserStream(ctx context.Context, bc *BinanceClient, symbol string, keepalive time.Duration) (*UserStream, error) {
+	s := &UserStream{
+		bc:        bc.fork(),
+		symbol:    symbol,
+		keepalive: keepalive,
+		notify:    make(chan struct{}, 1),
+		positions: make(map[futures.PositionSideType]StreamPosition),
+	}
+
+	done, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	go s.run(ctx, done)
+
+	return s, nil
+}
+
+// Updates сигнализирует о новых событиях потока. Для nil возвращает nil-канал.
+func (s *UserStream) Updates() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.notify
+}
+
+// TakeUpdates возвращает накопленные изменения ордеров и сообщает,
+// менялась ли позиция, затем очищает их.
+func (s *UserStream) TakeUpdates() (updates []OrderUpdate, moved bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	updates, moved = s.updates, s.moved
+	s.updates, s.moved = nil, false
+	return updates, moved
+}
+
+// HasPosition сообщает, открыта ли позиция по данным потока.
+func (s *UserStream) HasPosition() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.positions {
+		if p.Amount != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// connect получает ключ потока и подключается к нему.
+func (s *UserStream) connect(ctx context.Context) (<-chan struct{}, error) {
+	var key string
+	err := s.bc.call(ctx, true, func() (err error) {
+		key, err = s.bc.NewStartUserStreamService().Do(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("start user stream: %w", err)
+	}
+
+	done, stop, err := futures.WsUserDataServe(key, s.handle, func(err error) {
+		log.Println("user stream:", err)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connect user stream: %w", err)
+	}
+
+	s.mu.Lock()
+	s.listenKey, s.stop = key, stop
+	s.mu.Unlock()
+	fmt.Println("Подключен поток данных пользователя")
+
+	return done, nil
+}
+
+// run продлевает ключ потока и переподключается при обрыве до отмены ctx.
+func (s *UserStream) run(ctx context.Context, done <-chan struct{}) {
+	ticker := time.NewTicker(s.keepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.close()
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			key := s.listenKey
+			s.mu.Unlock()
+			err := s.bc.call(ctx, true, func() error {
+				return s.bc.NewKeepaliveUserStreamService().ListenKey(key).Do(ctx)
+			})
+			if err != nil {
+				log.Println("keepalive user stream:", err)
+			}
+		case <-done:
+			fmt.Println("Поток данных пользователя отключен, переподключение")
+			// пропущенные события восполнит опрос биржи на ближайшем шаге
+			s.signal()
+			for {
+				if !sleepContext(ctx, userStreamReconnectDelay) {
+					s.close()
+					return
+				}
+				var err error
+				if done, err = s.connect(ctx); err == nil {
+					break
+				}
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// close отключает поток и закрывает ключ.
+func (s *UserStream) close() {
+	s.disconnect()
+	s.mu.Lock()
+	key := s.listenKey
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.bc.NewCloseUserStreamService().ListenKey(key).Do(ctx); err != nil {
+		log.Println("close user stream:", err)
+	}
+}
+
+// handle обрабатывает событие потока.
+func (s *UserStream) handle(event *futures.WsUserDataEvent) {
+	switch event.Event {
+	case futures.UserDataEventTypeOrderTradeUpdate:
+		s.handleOrder(event.OrderTradeUpdate)
+	case futures.UserDataEventTypeAccountUpdate:
+		s.handleAccount(event.AccountUpdate)
+	case futures.UserDataEventTypeListenKeyExpired:
+		fmt.Println("Ключ потока данных пользователя истек")
+		s.disconnect()
+	}
+}
+
+// disconnect закрывает текущее подключение, run переподключится.
+func (s *UserStream) disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+func (s *UserStream) handleOrder(o futures.WsOrderTradeUpdate) {
+	if o.Symbol != s.symbol {
+		return
+	}
+
+	u := OrderUpdate{
+		ClientOrderID: o.ClientOrderID,
+		OrderID:       o.ID,
+		Side:          o.Side,
+		PositionSide:  o.PositionSide,
+		Status:        o.Status,
+		Time:          time.UnixMilli(o.TradeTime),
+	}
+	u.LastFilled, _ = strconv.ParseFloat(o.LastFilledQty, 64)
+	u.Filled, _ = strconv.ParseFloat(o.AccumulatedFilledQty, 64)
+	u.LastPrice, _ = strconv.ParseFloat(o.LastFilledPrice, 64)
+	u.RealizedPnL, _ = strconv.ParseFloat(o.RealizedPnL, 64)
+	u.Commission, _ = strconv.ParseFloat(o.Commission, 64)
+
+	if u.LastFilled > 0 {
+		fmt.Printf("Исполнен ордер %s: %s %f по %f, прибыль %.4f, статус %s\n",
+			u.ClientOrderID, o.Side, u.LastFilled, u.LastPrice, u.RealizedPnL, u.Status)
+	}
+
+	s.mu.Lock()
+	s.updates = append(s.updates, u)
+	s.mu.Unlock()
+
+	s.signal()
+}
+
+func (s *UserStream) handleAccount(a futures.WsAccountUpdate) {
+	changed := false
+
+	s.mu.Lock()
+	for _, p := range a.Positions {
+		if p.Symbol != s.symbol {
+			continue
+		}
+		var sp StreamPosition
+		sp.Amount, _ = strconv.ParseFloat(p.Amount, 64)
+		sp.EntryPrice, _ = strconv.ParseFloat(p.EntryPrice, 64)
+		if s.positions[p.Side] != sp {
+			s.positions[p.Side] = sp
+			s.moved = true
+			changed = true
+			fmt.Printf("Позиция %s: %f по %f (%s)\n", p.Side, sp.Amount, sp.EntryPrice, a.Reason)
+		}
+	}
+	s.mu.Unlock()
+
+	if changed {
+		s.signal()
+	}
+}
+
+// signal будит ожидающего Updates, не блокируясь.
+func (s *UserStream) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rocketlaunchr/dataframe-go"
+	"gonum.org/v1/gonum/stat"
+	"math"
+	"strings"
+	"time"
+)
+
+// WalkForwardOptions параметры walk-forward анализа.
+type WalkForwardOptions struct {
+	Optimize OptimizeOptions
+	// InSample кол-во свечей окна оптимизации.
+	InSample int
+	// OutSample кол-во свечей проверочного окна, следующего за окном
+	// оптимизации. На это же кол-во свечей сдвигаются окна.
+	OutSample int
+}
+
+// WalkForwardWindow результат одного шага walk-forward анализа.
+type WalkForwardWindow struct {
+	InFrom, InTo   time.Time
+	OutFrom, OutTo time.Time
+	// Best лучший на окне оптимизации набор параметров.
+	Best     OptimizationResult
+	InStats  BacktestStats
+	OutStats BacktestStats
+	Trades   []BacktestTrade
+	// Efficiency отношение прибыли на свечу вне выборки к прибыли
+	// на свечу в выборке.
+	Efficiency float64
+}
+
+// WalkForwardResult результат walk-forward анализа: окна и склеенная
+// из проверочных окон кривая капитала.
+type WalkForwardResult struct {
+	Ranges  []ParamRange
+	Windows []WalkForwardWindow
+	// OutOfSample сделки и капитал всех проверочных окон подряд.
+	OutOfSample BacktestResult
+}
+
+// walkForward делит историю на скользящие окна: на каждом окне
+// оптимизации подбираются параметры, которые затем проверяются
+// на следующем за ним окне.
+func walkForward(df *dataframe.DataFrame, cfg *Config, opts WalkForwardOptions) (*WalkForwardResult, error) {
+	if opts.InSample < 3 || opts.OutSample < 1 {
+		return nil, fmt.Errorf("in-sample must be at least 3 candles and out-of-sample at least 1")
+	}
+
+	nRows := df.NRows()
+	if nRows < opts.InSample+opts.OutSample {
+		return nil, fmt.Errorf("not enough candles for walk-forward: %d, need %d", nRows, opts.InSample+opts.OutSample)
+	}
+
+	dates := df.Series[df.MustNameToColumn("date")]
+	candleTime := func(i int) time.Time {
+		return time.UnixMilli(dates.Value(i).(int64))
+	}
+
+	balance := opts.Optimize.Backtest.Balance
+	res := &WalkForwardResult{
+		Ranges: opts.Optimize.Ranges,
+		OutOfSample: BacktestResult{
+			StartBalance: balance,
+			Equity:       []float64{balance},
+		},
+	}
+	peak := balance
+
+	for start := 0; start+opts.InSample+opts.OutSample <= nRows; start += opts.OutSample {
+		inEnd := start + opts.InSample - 1
+		outStart, outEnd := inEnd+1, inEnd+opts.OutSample
+
+		results, err := optimize(df.Copy(dataframe.RangeFinite(start, inEnd)), cfg, opts.Optimize)
+		if err != nil {
+			return nil, err
+		}
+		best := results[0]
+		if math.IsInf(best.Score, -1) {
+			return nil, fmt.Errorf("no parameters with at least %d trades in window %s - %s",
+				opts.Optimize.MinTrades, candleTime(start).Format(time.DateOnly), candleTime(inEnd).Format(time.DateOnly))
+		}
+
+		// проверочное окно прогоняется вместе с предшествующими свечами,
+		// чтобы индикаторы были рассчитаны к его началу
+		warmupStart := outStart - best.Params.Warmup()
+		if warmupStart < 0 {
+			warmupStart = 0
+		}
+		btOpts := opts.Optimize.Backtest
+		btOpts.Params = best.Params
+		btOpts.Balance = balance
+		btOpts.TradeFrom = candleTime(outStart)
+		out, err := runBacktest(df.Copy(dataframe.RangeFinite(warmupStart, outEnd)), cfg, btOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		w := WalkForwardWindow{
+			InFrom:   candleTime(start),
+			InTo:     candleTime(inEnd),
+			OutFrom:  candleTime(outStart),
+			OutTo:    candleTime(outEnd),
+			Best:     best,
+			InStats:  best.Stats,
+			OutStats: out.Stats(),
+			Trades:   out.Trades,
+		}
+		if w.InStats.NetProfit != 0 {
+			w.Efficiency = (w.OutStats.NetProfit / float64(opts.OutSample)) /
+				(w.InStats.NetProfit / float64(opts.InSample))
+		}
+		res.Windows = append(res.Windows, w)
+
+		for _, t := range out.Trades {
+			balance += t.PnL
+			peak = math.Max(peak, balance)
+			res.OutOfSample.MaxDrawdown = math.Max(res.OutOfSample.MaxDrawdown, peak-balance)
+			res.OutOfSample.Equity = append(res.OutOfSample.Equity, balance)
+		}
+		res.OutOfSample.Trades = append(res.OutOfSample.Trades, out.Trades...)
+	}
+
+	res.OutOfSample.FinalBalance = balance
+
+	return res, nil
+}
+
+// printWalkForwardReport выводит результаты окон, итог вне выборки
+// и дрейф параметров между окнами.
+func printWalkForwardReport(res *WalkForwardResult) {
+	for i, w := range res.Windows {
+		var vals []string
+		for j, r := range res.Ranges {
+			vals = append(vals, fmt.Sprintf("%s=%g", r.Name, w.Best.Values[j]))
+		}
+		fmt.Printf("Окно %d: оптимизация %s - %s, проверка %s - %s\n", i+1,
+			w.InFrom.Format(time.DateTime), w.InTo.Format(time.DateTime),
+			w.OutFrom.Format(time.DateTime), w.OutTo.Format(time.DateTime))
+		fmt.Printf("  параметры: %s\n", strings.Join(vals, " "))
+		fmt.Printf("  в выборке: сделок %d, прибыль %.2f; вне выборки: сделок %d, прибыль %.2f, просадка %.2f, эффективность %.2f\n",
+			w.InStats.Trades, w.InStats.NetProfit, w.OutStats.Trades, w.OutStats.NetProfit, w.OutStats.MaxDrawdown, w.Efficiency)
+	}
+
+	fmt.Println("\nИтог вне выборки:")
+	printBacktestReport(&res.OutOfSample)
+
+	if len(res.Windows) < 2 {
+		return
+	}
+	fmt.Println("\nДрейф параметров:")
+	for j, r := range res.Ranges {
+		vals := make([]float64, len(res.Windows))
+		for i, w := range res.Windows {
+			vals[i] = w.Best.Values[j]
+		}
+		mean, std := stat.MeanStdDev(vals, nil)
+		fmt.Printf("  %s: среднее %.4g, ст. отклонение %.4g, значения %v\n", r.Name, mean, std, vals)
+	}
+}